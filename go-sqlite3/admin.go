@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// startAdminServer serves the live RunSummary of the collector on addr
+// until ctx is done, for a look at a long run without the Prometheus
+// setup of --metrics-addr:
+//
+//	/summary  the RunSummary as JSON, as --summary json writes it at exit
+//	/hours    the statements by hour of the day, see hourBuckets
+//	/healthz  "ok", for a liveness probe
+//	/reset    (POST) zeroes the summary counters
+//	/trace/on, /trace/off
+//	          (POST) StartTrace and StopTrace
+func startAdminServer(ctx context.Context, addr string, c *TraceCollector) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/summary", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.RunSummary())
+	})
+	mux.HandleFunc("/hours", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.Hours())
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/reset", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		c.ResetSummary()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	for path, toggle := range map[string]func(){"/trace/on": c.StartTrace, "/trace/off": c.StopTrace} {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				http.Error(w, "use POST", http.StatusMethodNotAllowed)
+				return
+			}
+			toggle()
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+	srv := &http.Server{Handler: mux}
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("admin server got error: %s\n", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	log.Printf("serving the summary on http://%s/summary\n", ln.Addr())
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// asyncWriteDeadline is the longest Write waits for room in the buffer
+// of an asyncWriter before it drops the line.
+const asyncWriteDeadline = time.Millisecond
+
+type asyncLine struct {
+	line    []byte
+	isError bool // from WriteError
+}
+
+// asyncWriter hands the trace lines to a goroutine that writes them to w,
+// through a buffered channel, so that a slow destination does not hold
+// up SQLite in the trace callback. When the buffer is full, Write waits
+// asyncWriteDeadline at most and then drops the line and counts it.
+//
+// The lines reach w in order, but later than they are written, so they
+// may show up after what the program prints on stdout itself.
+type asyncWriter struct {
+	w       io.Writer
+	lines   chan asyncLine
+	done    chan struct{}
+	dropped atomic.Int64
+
+	closeOnce sync.Once
+}
+
+func newAsyncWriter(w io.Writer, buffer int) *asyncWriter {
+	a := &asyncWriter{
+		w:     w,
+		lines: make(chan asyncLine, buffer),
+		done:  make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *asyncWriter) run() {
+	defer close(a.done)
+	for l := range a.lines {
+		if ew, ok := a.w.(errorLineWriter); ok && l.isError {
+			ew.WriteError(string(l.line))
+		} else {
+			a.w.Write(l.line)
+		}
+	}
+}
+
+func (a *asyncWriter) Write(p []byte) (int, error) {
+	// p is the caller's to reuse once Write returns.
+	a.enqueue(asyncLine{line: append([]byte(nil), p...)})
+	return len(p), nil
+}
+
+// WriteError queues the line of a failed statement, for a w that tells
+// those apart; see errorLineWriter.
+func (a *asyncWriter) WriteError(line string) error {
+	a.enqueue(asyncLine{line: []byte(line), isError: true})
+	return nil
+}
+
+func (a *asyncWriter) enqueue(l asyncLine) {
+	select {
+	case a.lines <- l:
+		return
+	default:
+	}
+	t := time.NewTimer(asyncWriteDeadline)
+	defer t.Stop()
+	select {
+	case a.lines <- l:
+	case <-t.C:
+		a.dropped.Add(1)
+	}
+}
+
+// Dropped returns how many lines did not fit in the buffer.
+func (a *asyncWriter) Dropped() int64 {
+	return a.dropped.Load()
+}
+
+// Close writes out the lines still buffered and logs the dropped count,
+// if any. It does not close w; nothing may be written after it.
+func (a *asyncWriter) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.lines)
+		<-a.done
+		if n := a.Dropped(); n > 0 {
+			log.Printf("async trace writer dropped %d lines, raise --async-buffer\n", n)
+		}
+	})
+	return nil
+}
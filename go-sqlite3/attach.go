@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// attachment is one --attach name=path.
+type attachment struct {
+	name string
+	path string
+}
+
+// parseAttachment splits a --attach value.
+func parseAttachment(s string) (attachment, error) {
+	name, path, ok := strings.Cut(s, "=")
+	if !ok || name == "" || path == "" {
+		return attachment{}, fmt.Errorf("--attach %q: want name=path", s)
+	}
+	if strings.EqualFold(name, "main") || strings.EqualFold(name, "temp") {
+		return attachment{}, fmt.Errorf("--attach %q: %s is a reserved schema name", s, name)
+	}
+	return attachment{name: name, path: path}, nil
+}
+
+// attachAll attaches every database to conn. ATTACH is per connection,
+// so the ConnectHook runs this on each new connection of the pool;
+// attachConn detaches them again when the pool closes the connection,
+// the last time at db.Close on shutdown.
+func attachAll(conn *sqlite3.SQLiteConn, attachments []attachment) error {
+	for _, a := range attachments {
+		// Both are expressions to SQLite, so they bind like any value
+		// and need no quoting.
+		if _, err := conn.Exec("ATTACH DATABASE ? AS ?", []driver.Value{a.path, a.name}); err != nil {
+			return fmt.Errorf("attach %s as %s: %w", a.path, a.name, err)
+		}
+	}
+	return nil
+}
+
+// attachDriver wraps the go-sqlite3 driver for --attach, so that its
+// connections detach what the ConnectHook attached before they close.
+type attachDriver struct {
+	driver.Driver
+	attachments []attachment
+}
+
+func (d *attachDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &attachConn{conn: conn, attachments: d.attachments}, nil
+}
+
+// attachConn passes everything on to the connection it wraps, the way
+// ledgerConn does. Closing the connection would drop the attachments
+// too, but an explicit DETACH shows up in the trace next to its ATTACH,
+// and reports an attached database that is still busy.
+type attachConn struct {
+	conn        driver.Conn
+	attachments []attachment
+}
+
+func (c *attachConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *attachConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+}
+
+// Close detaches the databases in the reverse order of attachAll, then
+// closes the connection whether or not they all detached.
+func (c *attachConn) Close() error {
+	var detachErr error
+	for i := len(c.attachments) - 1; i >= 0; i-- {
+		a := c.attachments[i]
+		_, err := c.ExecContext(context.Background(), "DETACH DATABASE ?", []driver.NamedValue{{Ordinal: 1, Value: a.name}})
+		if err != nil && detachErr == nil {
+			detachErr = fmt.Errorf("detach %s: %w", a.name, err)
+		}
+	}
+	if err := c.conn.Close(); err != nil {
+		return err
+	}
+	return detachErr
+}
+
+func (c *attachConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *attachConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c *attachConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+func (c *attachConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
+
+func (c *attachConn) Ping(ctx context.Context) error {
+	return c.conn.(driver.Pinger).Ping(ctx)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestAttachDetaches checks that a connection detaches its --attach
+// databases before it closes, in the reverse order of the ATTACH.
+func TestAttachDetaches(t *testing.T) {
+	dir := t.TempDir()
+	code, stdout, trace := runMain(t, "--db", ":memory:", "--no-redact",
+		"--attach", "one="+filepath.Join(dir, "one.db"),
+		"--attach", "two="+filepath.Join(dir, "two.db"))
+	if code != exitOK {
+		t.Fatalf("exit code %d, want %d; stdout:\n%s", code, exitOK, stdout)
+	}
+	at := func(s string) int {
+		t.Helper()
+		i := strings.Index(trace, s)
+		if i < 0 {
+			t.Fatalf("trace has no %q:\n%s", s, trace)
+		}
+		return i
+	}
+	attach := at(`"ATTACH DATABASE ? AS ?"`)
+	detachTwo := at(`{"DETACH DATABASE ?"} expanded {"DETACH DATABASE 'two'"}`)
+	detachOne := at(`{"DETACH DATABASE ?"} expanded {"DETACH DATABASE 'one'"}`)
+	if !(attach < detachTwo && detachTwo < detachOne) {
+		t.Errorf("want ATTACH, then DETACH two, then DETACH one:\n%s", trace)
+	}
+}
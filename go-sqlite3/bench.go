@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// benchToken runs the built-in query n times on one prepared statement,
+// taken from stmts, in the caller's transaction, so neither prepare nor
+// begin is measured.
+//
+// It prints a single key=value line. Throughput comes from the wall clock;
+// the latencies are SQLite's own, taken from the TraceProfile events, so
+// they are only there when stmt and profile are in --trace.
+//
+// stmtTimeout, when non-zero, bounds every iteration on its own.
+func benchToken(ctx context.Context, tx querier, stmts *StmtCache, userName string, n int, stmtTimeout time.Duration, profiles *ProfileAggregator) error {
+	stmt, err := stmts.Get(ctx, tx, tokenSQL)
+	if err != nil {
+		log.Printf("prepare select token got error: %s\n", err)
+		return err
+	}
+
+	before := profiles.Histogram(tokenSQL)
+	var (
+		token    string
+		userid   int
+		deviceid int
+	)
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		qctx, cancel := withStmtTimeout(ctx, stmtTimeout)
+		err := stmt.QueryRowContext(qctx, userName).Scan(&token, &userid, &deviceid)
+		cancel()
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Printf("no matching user found for %q\n", userName)
+			return err
+		}
+		if err != nil {
+			log.Printf("bench iteration %d got error: %s\n", i, err)
+			return err
+		}
+	}
+	total := time.Since(start)
+
+	latencies := profiles.Histogram(tokenSQL).Since(before)
+	fmt.Printf("bench iterations=%d total_ns=%d ops_per_sec=%.1f samples=%d mean_ns=%d p50_ns=%d p99_ns=%d\n",
+		n, total.Nanoseconds(), float64(n)/total.Seconds(), latencies.Count(),
+		latencies.Mean().Nanoseconds(), latencies.Percentile(50).Nanoseconds(), latencies.Percentile(99).Nanoseconds())
+	return nil
+}
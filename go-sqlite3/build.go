@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log"
+	"runtime/debug"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// goSQLite3Version returns the go-sqlite3 module version the binary was
+// built with, or "unknown" without module information, e.g. under go run
+// of a GOPATH checkout.
+func goSQLite3Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/mattn/go-sqlite3" {
+			if dep.Replace != nil {
+				return dep.Version + " => " + dep.Replace.Path + " " + dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
+
+// logBuild logs the SQLite the database runs on, as SQLite itself reports
+// it, next to the library go-sqlite3 was compiled against, and with
+// compileOptions the PRAGMA compile_options list too.
+func logBuild(ctx context.Context, db querier, compileOptions bool) error {
+	var version string
+	if err := db.QueryRowContext(ctx, "SELECT sqlite_version()").Scan(&version); err != nil {
+		return err
+	}
+	_, _, sourceID := sqlite3.Version()
+	log.Printf("sqlite %s (%s), go-sqlite3 %s\n", version, sourceID, goSQLite3Version())
+	if !compileOptions {
+		return nil
+	}
+
+	rows, err := db.QueryContext(ctx, "PRAGMA compile_options")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var options []string
+	for rows.Next() {
+		var option string
+		if err := rows.Scan(&option); err != nil {
+			return err
+		}
+		options = append(options, option)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	log.Printf("sqlite compile options: %s\n", strings.Join(options, " "))
+	return nil
+}
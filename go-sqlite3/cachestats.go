@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// cacheSample is what --cache-stats reads around a statement. go-sqlite3
+// does not bind sqlite3_db_status, so SQLITE_DBSTATUS_CACHE_HIT and
+// CACHE_MISS are out of reach and there is no hit ratio to print; the
+// page and freelist counts of the main database, and whether the cache
+// may spill, are the closest a connection can ask for. A query that
+// grows the file shows up, one that only reads does not.
+type cacheSample struct {
+	pages    int64
+	freelist int64
+	spill    int64
+}
+
+// sampleCache reads a cacheSample on db. The PRAGMAs are traced like any
+// statement.
+func sampleCache(ctx context.Context, db querier) (cacheSample, error) {
+	var s cacheSample
+	for _, p := range []struct {
+		name string
+		dest *int64
+	}{
+		{"page_count", &s.pages},
+		{"freelist_count", &s.freelist},
+		{"cache_spill", &s.spill},
+	} {
+		if err := db.QueryRowContext(ctx, "PRAGMA "+p.name).Scan(p.dest); err != nil {
+			return s, fmt.Errorf("PRAGMA %s: %w", p.name, err)
+		}
+	}
+	return s, nil
+}
+
+// withCacheStats runs fn between two samples of db and writes the
+// difference, labelled with query. Without --cache-stats, collector is
+// nil and fn just runs.
+func withCacheStats(ctx context.Context, db querier, collector *TraceCollector, query string, fn func() error) error {
+	if collector == nil {
+		return fn()
+	}
+	before, err := sampleCache(ctx, db)
+	if err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	after, err := sampleCache(ctx, db)
+	if err != nil {
+		return err
+	}
+	collector.CacheStats(query, before, after)
+	return nil
+}
+
+// CacheStats writes the change between two cacheSamples.
+func (c *TraceCollector) CacheStats(query string, before, after cacheSample) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.settings.level != logInfo || c.eventsOnly() {
+		return
+	}
+	pages, freelist := after.pages-before.pages, after.freelist-before.freelist
+	w := c.settings.out
+	switch c.settings.format {
+	case "text":
+		fmt.Fprintf(w, "Trace: cache pages %+d (%d), freelist %+d (%d), spill %d {%q}\n",
+			pages, after.pages, freelist, after.freelist, after.spill, query)
+		return
+	case "logfmt":
+		io.WriteString(w, logfmtLine("event", "cache", "sql", query,
+			"pages_delta", pages, "pages", after.pages,
+			"freelist_delta", freelist, "freelist", after.freelist, "spill", after.spill))
+		return
+	}
+	line, _ := json.Marshal(struct {
+		Event         string `json:"event"`
+		SQL           string `json:"sql"`
+		PagesDelta    int64  `json:"pages_delta"`
+		Pages         int64  `json:"pages"`
+		FreelistDelta int64  `json:"freelist_delta"`
+		Freelist      int64  `json:"freelist"`
+		Spill         int64  `json:"spill"`
+	}{"cache", query, pages, after.pages, freelist, after.freelist, after.spill})
+	w.Write(append(line, '\n'))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// errCeiling is the cause of the cancellation of a run that --max-stmt-ms
+// stopped.
+var errCeiling = errors.New("statement over --max-stmt-ms")
+
+type ceilingStmt struct {
+	conn uintptr
+	sql  string
+}
+
+// stmtCeiling is the circuit breaker of --max-stmt-ms: the first profile
+// over the ceiling trips it, and it calls onTrip, once, right in the
+// trace callback, so that the statements after it already see the run
+// cancelled. Unlike --slo, which judges the run at the end, it stops the
+// run there.
+//
+// Like ProfileAggregator it remembers the text of each running statement
+// from its TraceStmt, for onTrip. It has no lock of its own, the
+// TraceCollector serializes the calls.
+type stmtCeiling struct {
+	max    time.Duration
+	sql    map[uintptr]ceilingStmt // StmtHandle -> its TraceStmt text
+	onTrip func(sql string, run time.Duration)
+
+	tripped bool
+}
+
+func newStmtCeiling(max time.Duration) *stmtCeiling {
+	return &stmtCeiling{max: max, sql: make(map[uintptr]ceilingStmt)}
+}
+
+func (c *stmtCeiling) Record(info sqlite3.TraceInfo) {
+	switch info.EventCode {
+	case sqlite3.TraceStmt:
+		c.sql[info.StmtHandle] = ceilingStmt{conn: info.ConnHandle, sql: info.StmtOrTrigger}
+	case sqlite3.TraceProfile:
+		sql := c.sql[info.StmtHandle].sql
+		delete(c.sql, info.StmtHandle)
+		if d := time.Duration(info.RunTimeNanosec); d > c.max && !c.tripped {
+			c.tripped = true
+			if c.onTrip != nil {
+				c.onTrip(sql, d)
+			}
+		}
+	case sqlite3.TraceClose:
+		// Statements whose profile never came.
+		for handle, s := range c.sql {
+			if s.conn == info.ConnHandle {
+				delete(c.sql, handle)
+			}
+		}
+	}
+}
+
+// OnCeiling sets what to do when a statement runs longer than
+// --max-stmt-ms; it runs in the trace callback and must not block.
+func (c *TraceCollector) OnCeiling(f func(sql string, run time.Duration)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ceiling != nil {
+		c.ceiling.onTrip = f
+	}
+}
+
+// CeilingExceeded reports whether a statement ran longer than
+// --max-stmt-ms.
+func (c *TraceCollector) CeilingExceeded() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ceiling != nil && c.ceiling.tripped
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+func TestStmtCeiling(t *testing.T) {
+	c := newStmtCeiling(10 * time.Millisecond)
+	var trips []string
+	c.onTrip = func(sql string, run time.Duration) { trips = append(trips, sql) }
+
+	c.Record(sqlite3.TraceInfo{EventCode: sqlite3.TraceStmt, ConnHandle: 0x10, StmtHandle: 0x20, StmtOrTrigger: "select 1"})
+	c.Record(sqlite3.TraceInfo{EventCode: sqlite3.TraceProfile, ConnHandle: 0x10, StmtHandle: 0x20, RunTimeNanosec: int64(time.Millisecond)})
+	if len(trips) != 0 || len(c.sql) != 0 {
+		t.Fatalf("under the ceiling: trips %v, kept %v", trips, c.sql)
+	}
+
+	// Interrupted: no profile, the close of the connection ends them.
+	c.Record(sqlite3.TraceInfo{EventCode: sqlite3.TraceStmt, ConnHandle: 0x10, StmtHandle: 0x21, StmtOrTrigger: "select 2"})
+	c.Record(sqlite3.TraceInfo{EventCode: sqlite3.TraceStmt, ConnHandle: 0x11, StmtHandle: 0x22, StmtOrTrigger: "select 3"})
+	c.Record(sqlite3.TraceInfo{EventCode: sqlite3.TraceClose, ConnHandle: 0x10})
+	if _, ok := c.sql[0x21]; ok || len(c.sql) != 1 {
+		t.Errorf("after the close of conn 0x10 kept %v, want the statement of conn 0x11 only", c.sql)
+	}
+
+	c.Record(sqlite3.TraceInfo{EventCode: sqlite3.TraceProfile, ConnHandle: 0x11, StmtHandle: 0x22, RunTimeNanosec: int64(time.Second)})
+	c.Record(sqlite3.TraceInfo{EventCode: sqlite3.TraceStmt, ConnHandle: 0x11, StmtHandle: 0x23, StmtOrTrigger: "select 4"})
+	c.Record(sqlite3.TraceInfo{EventCode: sqlite3.TraceProfile, ConnHandle: 0x11, StmtHandle: 0x23, RunTimeNanosec: int64(time.Second)})
+	if len(trips) != 1 || trips[0] != "select 3" || !c.tripped {
+		t.Errorf("trips %v, want once, for select 3", trips)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+)
+
+// startCheckpoints runs PRAGMA wal_checkpoint(PASSIVE) every interval
+// until ctx is done, reporting each result through the collector so that
+// it lands between the trace lines. It does nothing unless the database
+// is in WAL mode. The returned function stops the loop and waits for it.
+func startCheckpoints(ctx context.Context, db *sql.DB, journalMode string, interval time.Duration, collector *TraceCollector) func() {
+	if interval <= 0 || !strings.EqualFold(journalMode, "wal") {
+		return func() {}
+	}
+
+	return runEvery(ctx, interval, func(ctx context.Context, _ time.Time) {
+		// PASSIVE never waits for readers or writers,
+		// so the checkpoint cannot hold up the queries.
+		var busy, logFrames, checkpointed int
+		err := db.QueryRowContext(ctx, "PRAGMA wal_checkpoint(PASSIVE)").Scan(&busy, &logFrames, &checkpointed)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("wal checkpoint got error: %s\n", err)
+			}
+			return
+		}
+		collector.Checkpointed(busy, logFrames, checkpointed)
+	})
+}
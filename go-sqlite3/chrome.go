@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// chromeEvent is an event of the Chrome Trace Event format, as read by
+// chrome://tracing and Perfetto. Times are in microseconds.
+type chromeEvent struct {
+	Name  string                 `json:"name"`
+	Cat   string                 `json:"cat"`
+	Phase string                 `json:"ph"`
+	Scope string                 `json:"s,omitempty"`
+	TS    float64                `json:"ts"`
+	Dur   *float64               `json:"dur,omitempty"`
+	PID   int                    `json:"pid"`
+	TID   uintptr                `json:"tid"`
+	Args  map[string]interface{} `json:"args,omitempty"`
+}
+
+// ChromeFormatter writes one complete ("X") event per statement, from its
+// TraceStmt to its TraceProfile as paired by wallClock, named by the
+// fingerprint and with the connection handle as the thread, so that the
+// statements of a connection line up on one track. The other events give
+// no line; a statement whose TraceStmt was filtered out gives none either.
+//
+// The lines are the elements of the JSON array chromeWriter writes.
+type ChromeFormatter struct {
+	clock *wallClock
+	sql   map[uintptr]string // StmtHandle -> its TraceStmt text
+}
+
+func newChromeFormatter() *ChromeFormatter {
+	return &ChromeFormatter{clock: newWallClock(), sql: make(map[uintptr]string)}
+}
+
+func (f *ChromeFormatter) Format(info sqlite3.TraceInfo) (string, bool) {
+	wall, timed := f.clock.Record(info)
+	switch info.EventCode {
+	case sqlite3.TraceStmt:
+		f.sql[info.StmtHandle] = info.StmtOrTrigger
+		return "", true
+	case sqlite3.TraceClose:
+		// The statements wallClock dropped.
+		for handle := range f.sql {
+			if _, ok := f.clock.started[handle]; !ok {
+				delete(f.sql, handle)
+			}
+		}
+	}
+	if !timed {
+		return "", true
+	}
+	sql := f.sql[info.StmtHandle]
+	delete(f.sql, info.StmtHandle)
+
+	end := sinceStart()
+	dur := float64(wall.Microseconds())
+	ev := chromeEvent{
+		Name:  fingerprint(sql),
+		Cat:   "sqlite",
+		Phase: "X",
+		TS:    float64((end - wall).Microseconds()),
+		Dur:   &dur,
+		PID:   1,
+		TID:   info.ConnHandle,
+		Args: map[string]interface{}{
+			"sql":         sql,
+			"stmt_handle": fmt.Sprintf("0x%x", info.StmtHandle),
+			"auto_commit": info.AutoCommit,
+			"run_time_ns": info.RunTimeNanosec,
+		},
+	}
+	if isDBError(info.DBError) {
+		ev.Args["db_error"] = info.DBError.Error()
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Sprintf("Trace: failed to marshal event: %s\n", err), false
+	}
+	return string(line) + "\n", false
+}
+
+// chromeWriter writes the lines of ChromeFormatter as a JSON array: the
+// "[" before the first, a comma between them and the "]" on Close, which
+// dbMain defers so that a run ending early still leaves a file the viewer
+// loads. Any other line, such as the ConnectHook one, becomes a global
+// instant ("i") event, with a JSON line as its args and any other as its
+// "line" arg.
+type chromeWriter struct {
+	mu    sync.Mutex
+	w     io.Writer
+	count int
+}
+
+func newChromeWriter(w io.Writer) *chromeWriter {
+	return &chromeWriter{w: w}
+}
+
+func (c *chromeWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		// The comma goes first, so that each write ends its line.
+		if c.count == 0 {
+			b.WriteString("[\n")
+		} else {
+			b.WriteString(",")
+		}
+		c.count++
+		b.WriteString(toChromeEvent(line) + "\n")
+	}
+	if _, err := io.WriteString(c.w, b.String()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close ends the array; with no event at all it is an empty one.
+func (c *chromeWriter) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	end := "]\n"
+	if c.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(c.w, end)
+	return err
+}
+
+// toChromeEvent returns line as a Chrome trace event. The instant events
+// are stamped when written, which is close enough for the few lines that
+// are not statements.
+func toChromeEvent(line string) string {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &fields); err == nil && fields["ph"] != nil {
+		return line
+	}
+	ev := chromeEvent{
+		Name:  "trace",
+		Cat:   "sqlite",
+		Phase: "i",
+		Scope: "g",
+		TS:    float64(sinceStart().Microseconds()),
+		PID:   1,
+	}
+	if fields != nil {
+		ev.Args = fields
+		if name, ok := fields["event"].(string); ok {
+			ev.Name = name
+		}
+	} else {
+		ev.Args = map[string]interface{}{"line": line}
+	}
+	b, _ := json.Marshal(ev)
+	return string(b)
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel"
+)
+
+// logLevel decides which events are written; unlike the event mask it
+// does not change what SQLite delivers, so the aggregates see everything.
+type logLevel int
+
+const (
+	logInfo  logLevel = iota // every event
+	logError                 // only events that carry a database error
+	logOff                   // none
+)
+
+func parseLogLevel(s string) (logLevel, error) {
+	switch s {
+	case "info":
+		return logInfo, nil
+	case "error":
+		return logError, nil
+	case "off":
+		return logOff, nil
+	}
+	return 0, fmt.Errorf("unknown --log-level %q, want info, error or off", s)
+}
+
+// traceSettings is the sample's counterpart of sqlite3.TraceConfig:
+// the knobs consulted by the callback for every event.
+type traceSettings struct {
+	level logLevel
+
+	// slowThreshold, when non-zero, keeps only TraceProfile events
+	// that took at least that long; the other events carry no timing.
+	slowThreshold time.Duration
+
+	// sampleRate is the share of statements written out, decided at
+	// random from sampleSeed; 1 keeps them all.
+	sampleRate float64
+	sampleSeed int64
+
+	// match and exclude, when set, keep only the statements whose text
+	// matches the one and not the other, see sqlFilter.
+	match, exclude *regexp.Regexp
+
+	// redact replaces literals in ExpandedSQL with '?' before printing.
+	redact bool
+
+	// format names the TraceFormatter of the events, and the
+	// format of the collector's own lines: text, json or logfmt.
+	format string
+
+	// checkInvariants verifies the order of the events, see InvariantChecker.
+	checkInvariants bool
+
+	// wallTime follows each profile line with the Go side wall time of
+	// the statement, see wallClock.
+	wallTime bool
+
+	// rowCounts follows each profile line with the rows the statement
+	// scanned, see rowCounter.
+	rowCounts bool
+
+	// verbose adds a %#v dump of each event before its line, see
+	// writeVerbose.
+	verbose bool
+
+	// goid adds the id of the goroutine running the callback, see goid.
+	goid bool
+
+	// deterministic writes handleIDs for the handles and zero timings,
+	// for traces that can be diffed against a golden file.
+	deterministic bool
+
+	// maxStmt, when non-zero, is the run time ceiling of --max-stmt-ms,
+	// see stmtCeiling.
+	maxStmt time.Duration
+
+	// tz is the time zone of the hours hourBuckets counts by; nil is
+	// the local one.
+	tz *time.Location
+
+	// color wraps the text lines of failed statements and slow profiles
+	// in ANSI colors, see colorLine.
+	color bool
+
+	// template replaces the line of the text format; defaultTextTemplate
+	// leaves TextFormatter, which writes the same, at work.
+	template string
+
+	// warnNoExpand adds a note after a statement with parameters that
+	// came without its expanded SQL.
+	warnNoExpand bool
+
+	// trackStmts keeps the stmtLedger of the prepared statements.
+	trackStmts bool
+
+	// ioAccounting adds the bytes read and written to the profile lines;
+	// the databases must be opened with the counting VFS, see countingVFS.
+	ioAccounting bool
+
+	// maxEvents, when non-zero, stops the trace after that many lines.
+	maxEvents int64
+
+	// maxSQLLen, when non-zero, cuts the SQL texts to that many runes
+	// in the output; the aggregates and the sink see them whole.
+	maxSQLLen int
+
+	// out receives the formatted events; nil means os.Stdout.
+	out io.Writer
+
+	// sink, if set, also stores the events that are written to out.
+	sink *TraceSink
+
+	// metrics, if set, is fed every event, printed or not.
+	metrics *promMetrics
+
+	// meter, if set, is fed every event like metrics, for OTLP.
+	meter *OTelMeter
+}
+
+// TraceCollector holds all the state the trace callback builds up.
+//
+// go-sqlite3 invokes the callback from cgo, on the goroutine of whichever
+// connection is running a statement, so with several connections the
+// callbacks run concurrently. The collector serializes them, which also
+// keeps the lines of two connections from interleaving in the output.
+type TraceCollector struct {
+	settings traceSettings
+	format   TraceFormatter
+
+	mu       sync.Mutex
+	connects int // ConnectHook runs so far
+	profiles *ProfileAggregator
+	spans    *OTelTracer
+	rows     *rowCounter // nil without --row-counts
+	wall     *wallClock  // nil without --wall-time
+	dbErrors *errorTally
+	sampled  *sampler
+	filtered *sqlFilter
+	hours    *hourBuckets
+	conns    *connTracker
+	ceiling  *stmtCeiling // nil without --max-stmt-ms
+	counts   throughput
+	requests *requestRegistry
+	checker  *InvariantChecker // nil without --check-invariants
+	ids      *handleIDs        // nil without --deterministic
+	vfs      *countingVFS      // nil without --io-accounting
+	ledger   *stmtLedger       // nil without --track-stmts
+
+	// statements traced in autocommit mode and inside a transaction
+	autoCommitStmts int
+	txStmts         int
+
+	// events written, and whether --max-events cut the trace; atomic,
+	// so that the callbacks past the cap return without taking mu.
+	emitted   atomic.Int64
+	truncated atomic.Bool
+
+	// stopped is set between StopTrace and StartTrace; atomic like
+	// emitted, so that the dropped events cost no more than the check.
+	stopped atomic.Bool
+}
+
+func newTraceCollector(settings traceSettings) *TraceCollector {
+	if settings.out == nil {
+		settings.out = os.Stdout
+	}
+	c := &TraceCollector{
+		settings: settings,
+		profiles: newProfileAggregator(),
+		// The global TracerProvider is a no-op unless startOTLPTracer
+		// installed one, for --otlp-endpoint.
+		spans:    newOTelTracer(otel.Tracer("github.com/leslie-wang/samples/go-sqlite3")),
+		dbErrors: newErrorTally(),
+		sampled:  newSampler(settings.sampleRate, settings.sampleSeed),
+		filtered: newSQLFilter(settings.match, settings.exclude),
+		hours:    newHourBuckets(settings.tz),
+		conns:    newConnTracker(),
+		requests: newRequestRegistry(),
+	}
+	// The name and template were checked when the options were parsed.
+	c.format, _ = newTraceFormatter(settings.format)
+	if settings.template != defaultTextTemplate {
+		c.format, _ = newTemplateFormatter(settings.template)
+	}
+	if settings.checkInvariants {
+		c.checker = newInvariantChecker()
+	}
+	if settings.deterministic {
+		c.ids = newHandleIDs()
+	}
+	if settings.wallTime {
+		c.wall = newWallClock()
+	}
+	if settings.rowCounts {
+		c.rows = newRowCounter()
+	}
+	if settings.maxStmt > 0 {
+		c.ceiling = newStmtCeiling(settings.maxStmt)
+	}
+	if settings.trackStmts {
+		c.ledger = newStmtLedger()
+	}
+	if settings.ioAccounting {
+		c.vfs = newCountingVFS()
+	}
+	return c
+}
+
+// Callback is installed as the sqlite3.TraceConfig callback of every
+// connection; it aggregates the event, then filters and formats it.
+func (c *TraceCollector) Callback(info sqlite3.TraceInfo) int {
+	return c.callback(info, "")
+}
+
+// CallbackFor is Callback for the connections of one of several
+// databases: their trace lines are prefixed with db, the database path.
+func (c *TraceCollector) CallbackFor(db string) func(sqlite3.TraceInfo) int {
+	return func(info sqlite3.TraceInfo) int {
+		return c.callback(info, db)
+	}
+}
+
+func (c *TraceCollector) callback(info sqlite3.TraceInfo, db string) int {
+	if c.stopped.Load() {
+		return 0
+	}
+	// Past --max-events nothing is recorded either: the point of the
+	// cap is that a runaway run costs no more than this check.
+	if max := c.settings.maxEvents; max > 0 && c.emitted.Load() >= max {
+		if c.truncated.CompareAndSwap(false, true) && !c.eventsOnly() {
+			c.mu.Lock()
+			writeTruncated(c.settings.out, c.settings.format, max)
+			c.mu.Unlock()
+		}
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Always record: the profile aggregator and the spans need
+	// the TraceStmt events even when they are not printed.
+	c.profiles.Record(info)
+	c.spans.Record(info)
+	var (
+		scanned map[uintptr]int
+		wall    time.Duration
+		timed   bool
+		ioCount ioBytes
+		ioTimed bool
+	)
+	if c.rows != nil {
+		scanned = c.rows.Record(info)
+	}
+	if c.wall != nil {
+		wall, timed = c.wall.Record(info)
+	}
+	if c.vfs != nil {
+		ioCount, ioTimed = c.vfs.Record(info)
+	}
+	c.dbErrors.Record(info)
+	c.counts.Record(info)
+	c.hours.Record(info)
+	c.conns.Record(info)
+	if c.ceiling != nil {
+		c.ceiling.Record(info)
+	}
+	requestID := c.requests.Record(info)
+	if c.checker != nil {
+		c.checker.Record(info)
+	}
+	if info.EventCode == sqlite3.TraceStmt {
+		if info.AutoCommit {
+			c.autoCommitStmts++
+		} else {
+			c.txStmts++
+		}
+	}
+	keep := c.sampled.Keep(info)
+	if !c.filtered.Keep(info) {
+		keep = false
+	}
+	if c.settings.metrics != nil {
+		c.settings.metrics.Record(info)
+	}
+	if c.settings.meter != nil {
+		c.settings.meter.Record(info)
+	}
+
+	switch {
+	case c.settings.level == logOff,
+		c.settings.level == logError && !isDBError(info.DBError),
+		!keep:
+		return 0
+	}
+	if c.settings.slowThreshold > 0 {
+		if info.EventCode != sqlite3.TraceProfile ||
+			time.Duration(info.RunTimeNanosec) < c.settings.slowThreshold {
+			return 0
+		}
+	}
+	// Counted before the redaction, which hides the types of the values.
+	var params boundParams
+	if info.EventCode == sqlite3.TraceStmt && info.ExpandedSQL != "" && info.ExpandedSQL != info.StmtOrTrigger {
+		params = diffParams(info.StmtOrTrigger, info.ExpandedSQL)
+	}
+	// Identical texts mean nothing was bound, so there is nothing to hide.
+	redacted := false
+	if c.settings.redact && info.ExpandedSQL != "" && info.ExpandedSQL != info.StmtOrTrigger {
+		info.ExpandedSQL = redactExpandedSQL(info.ExpandedSQL)
+		redacted = true
+	}
+	if c.settings.sink != nil {
+		c.settings.sink.Insert(info)
+	}
+	// Only the output: the aggregates above have the real values.
+	if c.ids != nil {
+		info.ConnHandle = c.ids.conn(info.ConnHandle)
+		info.StmtHandle = c.ids.stmt(info.StmtHandle)
+		info.RunTimeNanosec = 0
+		wall = 0
+	}
+	sqlLen := 0 // the longer text, when cut
+	if c.settings.maxSQLLen > 0 {
+		var cutStmt, cutExpanded bool
+		n := utf8.RuneCountInString(info.StmtOrTrigger)
+		if e := utf8.RuneCountInString(info.ExpandedSQL); e > n {
+			n = e
+		}
+		info.StmtOrTrigger, cutStmt = truncateSQL(info.StmtOrTrigger, c.settings.maxSQLLen)
+		info.ExpandedSQL, cutExpanded = truncateSQL(info.ExpandedSQL, c.settings.maxSQLLen)
+		if cutStmt || cutExpanded {
+			sqlLen = n
+		}
+	}
+	var (
+		line string
+		skip bool
+	)
+	if rf, ok := c.format.(redactionFormatter); ok && redacted {
+		line, skip = rf.FormatRedacted(info)
+	} else {
+		line, skip = c.format.Format(info)
+	}
+	if skip {
+		return 0
+	}
+	// The keys of ndjson are fixed, nothing is added to its lines.
+	if c.settings.format != "ndjson" {
+		if sqlLen > 0 {
+			line = appendSQLLen(c.settings.format, line, sqlLen)
+		}
+		if ioTimed {
+			line = appendIO(c.settings.format, line, ioCount)
+		}
+		if requestID != "" {
+			line = appendRequestID(c.settings.format, line, requestID)
+		}
+		if params.total > 0 {
+			line = appendParams(c.settings.format, line, params)
+		}
+		if c.settings.goid {
+			line = appendGoid(c.settings.format, line, goid())
+		}
+		if db != "" {
+			line = prefixDB(c.settings.format, line, db)
+		}
+	}
+	if c.settings.color {
+		line = colorLine(line, info, c.settings.slowThreshold)
+	}
+	// Checked again under mu, which the count is kept under: callbacks
+	// that passed the check above together must not all write.
+	if max := c.settings.maxEvents; max > 0 && c.emitted.Load() >= max {
+		if c.truncated.CompareAndSwap(false, true) && !c.eventsOnly() {
+			writeTruncated(c.settings.out, c.settings.format, max)
+		}
+		return 0
+	}
+	if c.settings.verbose {
+		writeVerbose(c.settings.out, c.settings.format, info)
+	}
+	// One Write per event keeps lines whole, also across a file rotation.
+	if ew, ok := c.settings.out.(errorLineWriter); ok && isDBError(info.DBError) {
+		ew.WriteError(line)
+	} else {
+		io.WriteString(c.settings.out, line)
+	}
+	c.emitted.Add(1)
+	if c.eventsOnly() {
+		return 0
+	}
+	if c.settings.warnNoExpand {
+		if n := missingExpansion(info); n > 0 {
+			writeNoExpand(c.settings.out, c.settings.format, info.StmtHandle, n)
+		}
+	}
+	if timed {
+		writeWallTime(c.settings.out, c.settings.format, info.StmtHandle, info.RunTimeNanosec, wall)
+	}
+	for stmt, rows := range scanned {
+		if c.ids != nil {
+			stmt = c.ids.stmt(stmt)
+		}
+		writeRowCount(c.settings.out, c.settings.format, stmt, rows)
+	}
+	return 0
+}
+
+// eventsOnly reports whether the trace has room for the event lines only,
+// none of the collector's own: ndjson, whose every line is loaded into
+// the warehouse as an event.
+func (c *TraceCollector) eventsOnly() bool {
+	return c.settings.format == "ndjson"
+}
+
+// Connected writes a line for every run of the ConnectHook, i.e. for every
+// new connection in the database/sql pool. go-sqlite3 does not expose the
+// handle the trace events carry, so the line has a sequence number instead;
+// the handle shows up on the events that follow.
+func (c *TraceCollector) Connected(conn *sqlite3.SQLiteConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.connects++
+	c.conns.Connected()
+	if c.settings.level != logInfo || c.eventsOnly() {
+		return
+	}
+	file := conn.GetFilename("main")
+	switch c.settings.format {
+	case "text":
+		fmt.Fprintf(c.settings.out, "Trace: ConnectHook #%d file {%q}\n", c.connects, file)
+		return
+	case "logfmt":
+		io.WriteString(c.settings.out, logfmtLine("event", "connect", "connect", c.connects, "file", file))
+		return
+	}
+	line, _ := json.Marshal(struct {
+		Event   string `json:"event"`
+		Connect int    `json:"connect"`
+		File    string `json:"file"`
+	}{"connect", c.connects, file})
+	c.settings.out.Write(append(line, '\n'))
+}
+
+// Summary writes the run's totals: how many statements ran in autocommit
+// mode and how many inside a transaction, the connections they ran on,
+// then the database errors.
+func (c *TraceCollector) Summary(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "autocommit: %d, transaction: %d\n", c.autoCommitStmts, c.txStmts)
+	fmt.Fprintf(w, "%d distinct connections used, %d reuses\n", c.conns.conns, c.conns.reuses)
+	c.dbErrors.Report(w)
+}
+
+// InvariantViolations returns the number of out of order events seen,
+// 0 also when they are not checked.
+func (c *TraceCollector) InvariantViolations() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.checker == nil {
+		return 0
+	}
+	return c.checker.Violations()
+}
+
+// Snapshot writes the statements and rows traced in the last interval,
+// then starts counting the next one.
+func (c *TraceCollector) Snapshot(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.eventsOnly() {
+		writeThroughput(c.settings.out, c.settings.format, "interval", interval, c.counts.stmts, c.counts.rows)
+	}
+	c.counts.stmts, c.counts.rows = 0, 0
+}
+
+// Totals writes the statements and rows traced over the whole run.
+func (c *TraceCollector) Totals(elapsed time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.eventsOnly() {
+		return
+	}
+	writeThroughput(c.settings.out, c.settings.format, "total", elapsed, c.counts.totalStmts, c.counts.totalRows)
+}
+
+// DatabaseSize writes the page counts of the database file.
+func (c *TraceCollector) DatabaseSize(pages, pageSize, freelist int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.settings.level != logInfo || c.eventsOnly() {
+		return
+	}
+	writeDatabaseSize(c.settings.out, c.settings.format, pages, pageSize, freelist)
+}
+
+// Checkpointed writes the result of a PRAGMA wal_checkpoint: whether it
+// was blocked, the frames in the WAL, and how many of them were copied
+// back into the database.
+func (c *TraceCollector) Checkpointed(busy, logFrames, checkpointed int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.settings.level != logInfo || c.eventsOnly() {
+		return
+	}
+	switch c.settings.format {
+	case "text":
+		fmt.Fprintf(c.settings.out, "Trace: checkpoint busy %d log %d checkpointed %d\n", busy, logFrames, checkpointed)
+		return
+	case "logfmt":
+		io.WriteString(c.settings.out, logfmtLine("event", "checkpoint", "busy", busy, "log", logFrames, "checkpointed", checkpointed))
+		return
+	}
+	line, _ := json.Marshal(struct {
+		Event        string `json:"event"`
+		Busy         int    `json:"busy"`
+		Log          int    `json:"log"`
+		Checkpointed int    `json:"checkpointed"`
+	}{"checkpoint", busy, logFrames, checkpointed})
+	c.settings.out.Write(append(line, '\n'))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// newTestCollector returns a collector writing the text format to out,
+// with every event kept.
+func newTestCollector(out *bytes.Buffer) *TraceCollector {
+	return newTraceCollector(traceSettings{
+		out:        out,
+		format:     "text",
+		template:   defaultTextTemplate,
+		sampleRate: 1,
+	})
+}
+
+// openTestDB opens a database file under t's temp dir through a tracing
+// driver feeding c, with every event traced.
+func openTestDB(t *testing.T, c *TraceCollector) *sql.DB {
+	t.Helper()
+	mask, err := parseEventMask("stmt,profile,row,close")
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := newTracingDriver(&options{eventMask: mask, busyTimeoutMs: -1}, c, c.Callback)
+	db := sql.OpenDB(driverConnector{drv: drv, dsn: filepath.Join(t.TempDir(), "test.db")})
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestCollectorConcurrent runs queries on several connections at once
+// through one collector; run with -race it checks that the collector
+// serializes its callbacks.
+func TestCollectorConcurrent(t *testing.T) {
+	const (
+		workers = 8
+		queries = 50
+	)
+	var out bytes.Buffer
+	c := newTestCollector(&out)
+	db := openTestDB(t, c)
+
+	// A connection each, taken up front, so that the callbacks of
+	// different connections do run at the same time.
+	ctx := context.Background()
+	conns := make([]*sql.Conn, workers)
+	for w := range conns {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns[w] = conn
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w, conn := range conns {
+		wg.Add(1)
+		go func(w int, conn *sql.Conn) {
+			defer wg.Done()
+			for i := 0; i < queries; i++ {
+				var n int
+				if err := conn.QueryRowContext(ctx, fmt.Sprintf("SELECT %d", w*queries+i)).Scan(&n); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w, conn)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	s := c.RunSummary()
+	if s.Statements != workers*queries {
+		t.Errorf("statements = %d, want %d", s.Statements, workers*queries)
+	}
+	if s.Rows != workers*queries {
+		t.Errorf("rows = %d, want %d", s.Rows, workers*queries)
+	}
+	if s.Connections != workers {
+		t.Errorf("connections = %d, want %d", s.Connections, workers)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// ANSI colors of the text trace lines, see colorLine.
+const (
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+	ansiReset  = "\x1b[0m"
+)
+
+// isTerminal reports whether f is a terminal, as far as a character
+// device tells; --color auto colors only then.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// useColor resolves --color for the trace lines going to out.
+func useColor(mode string, out interface{}) bool {
+	switch mode {
+	case "always":
+		return true
+	case "auto":
+		// Only stdout itself: not a file, nor a tee or syslog beside it.
+		return out == os.Stdout && isTerminal(os.Stdout)
+	}
+	return false
+}
+
+// colorLine colors the text line of a failed statement red, and that of
+// a profile at or over slow yellow; a zero slow colors no profile.
+func colorLine(line string, info sqlite3.TraceInfo, slow time.Duration) string {
+	color := ""
+	switch {
+	case isDBError(info.DBError):
+		color = ansiRed
+	case info.EventCode == sqlite3.TraceProfile && slow > 0 && time.Duration(info.RunTimeNanosec) >= slow:
+		color = ansiYellow
+	default:
+		return line
+	}
+	return color + strings.TrimSuffix(line, "\n") + ansiReset + "\n"
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// compareMain runs the queries of opts against every --db database, each
+// opened through a tracing driver of its own so that its trace lines are
+// labelled with its path, and prints the results side by side.
+//
+// The queries run in autocommit mode and nothing is created first: the
+// databases are compared as they are, e.g. before and after a migration.
+// It returns exitMismatch if any query came back differently.
+func compareMain(opts *options, collector *TraceCollector) int {
+	timeoutCtx, cancel := withTimeout(context.Background(), opts.timeout)
+	defer cancel()
+	ctx, stop := signal.NotifyContext(timeoutCtx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	dbs := make([]*sql.DB, len(opts.dbPaths))
+	for i, path := range opts.dbPaths {
+		var drv driver.Driver = &sqlite3.SQLiteDriver{}
+		if !opts.quiet {
+			drv = newTracingDriver(opts, collector, collector.CallbackFor(path))
+		}
+		db, _, code := openDB(drv, path, opts)
+		if code != exitOK {
+			return code
+		}
+		defer db.Close()
+		dbs[i] = db
+	}
+
+	code := exitOK
+	for _, q := range opts.queries {
+		results := make([]*queryResult, len(dbs))
+		for i, db := range dbs {
+			results[i] = &queryResult{}
+			qctx, cancel := withStmtTimeout(ctx, opts.stmtTimeout)
+			err := runQuery(qctx, db, results[i], q.SQL, q.Args...)
+			cancel()
+			if err != nil {
+				category, _ := classifyError(err)
+				log.Printf("query %q on %s got %s error: %s\n", q.SQL, opts.dbPaths[i], category, err)
+				logCancellation(ctx, timeoutCtx)
+				return exitCodeFor(err)
+			}
+		}
+		if !writeComparison(os.Stdout, q.SQL, opts.dbPaths, results) {
+			code = exitMismatch
+		}
+	}
+	fmt.Println("--------- complete --------")
+	return code
+}
+
+// queryResult is a RowWriter keeping the result of a query in memory,
+// each row formatted to one string, for writeComparison.
+type queryResult struct {
+	columns string
+	rows    []string
+}
+
+func (r *queryResult) WriteHeader(columns []string) error {
+	r.columns = strings.Join(columns, " | ")
+	return nil
+}
+
+func (r *queryResult) WriteRow(values []interface{}) error {
+	fields := make([]string, len(values))
+	for i, v := range values {
+		fields[i] = formatValue(v)
+	}
+	r.rows = append(r.rows, strings.Join(fields, " | "))
+	return nil
+}
+
+func (r *queryResult) Flush() error {
+	return nil
+}
+
+// writeComparison writes the results of query, one column per database,
+// with the column names, the row count and then the rows in the order
+// they were returned. A line that differs between the databases ends
+// in MISMATCH; the return value tells whether there was none.
+func writeComparison(w io.Writer, query string, paths []string, results []*queryResult) bool {
+	fmt.Fprintf(w, "--------- compare: %s\n", query)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	same := true
+	line := func(label string, cell func(r *queryResult) string) {
+		fmt.Fprint(tw, label)
+		first := cell(results[0])
+		differs := false
+		for _, r := range results {
+			c := cell(r)
+			differs = differs || c != first
+			fmt.Fprintf(tw, "\t%s", c)
+		}
+		if differs {
+			fmt.Fprint(tw, "\tMISMATCH")
+			same = false
+		}
+		fmt.Fprintln(tw)
+	}
+
+	for _, p := range paths {
+		fmt.Fprintf(tw, "\t%s", p)
+	}
+	fmt.Fprintln(tw)
+	line("columns", func(r *queryResult) string { return r.columns })
+	line("rows", func(r *queryResult) string { return strconv.Itoa(len(r.rows)) })
+	n := 0
+	for _, r := range results {
+		if len(r.rows) > n {
+			n = len(r.rows)
+		}
+	}
+	for i := 0; i < n; i++ {
+		line(strconv.Itoa(i+1), func(r *queryResult) string {
+			if i >= len(r.rows) {
+				return "-"
+			}
+			return r.rows[i]
+		})
+	}
+	tw.Flush()
+	return same
+}
+
+// prefixDB labels a trace line with the database it came from.
+func prefixDB(format, line, db string) string {
+	switch {
+	case isJSONFormat(format):
+		v, _ := json.Marshal(db)
+		return `{"db":` + string(v) + "," + strings.TrimPrefix(line, "{")
+	case format == "logfmt":
+		return "db=" + logfmtValue(db) + " " + line
+	}
+	return "[" + db + "] " + line
+}
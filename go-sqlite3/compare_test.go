@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPrefixDB(t *testing.T) {
+	const db = "/tmp/a\x01b\U0001F600.db"
+	line := prefixDB("json", `{"event":"stmt"}`+"\n", db)
+	var got struct {
+		DB    string `json:"db"`
+		Event string `json:"event"`
+	}
+	if err := json.Unmarshal([]byte(line), &got); err != nil {
+		t.Fatalf("%s: %s", err, line)
+	}
+	if got.DB != db || got.Event != "stmt" {
+		t.Errorf("got db %q, event %q: %s", got.DB, got.Event, line)
+	}
+
+	if line := prefixDB("logfmt", "event=stmt\n", "x.db"); line != "db=x.db event=stmt\n" {
+		t.Errorf("logfmt: %q", line)
+	}
+	if line := prefixDB("text", "Trace: ev stmt\n", "x.db"); !strings.HasPrefix(line, "[x.db] ") {
+		t.Errorf("text: %q", line)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// connTracker follows the ConnHandle values of the trace to show how the
+// database/sql pool hands its connections out. The pool's checkouts are
+// not traced, so a use is a run of statements on one connection, and a
+// reuse a use of a connection that ran statements before: whenever the
+// statements go back to a connection after another one ran some.
+//
+// A handle's TraceClose is its last event. The allocator readily gives
+// the next connection the address of the one just closed, so a closed
+// handle that comes back is a new connection if a ConnectHook ran for it,
+// and logged if none did.
+//
+// Like rowCounter it has no lock, the TraceCollector serializes the calls.
+type connTracker struct {
+	seen   map[uintptr]bool // ConnHandle -> used since the start or the last reset
+	closed map[uintptr]bool // ConnHandle -> TraceClose seen
+	fresh  int              // ConnectHooks whose connection has not been traced yet
+	last   uintptr          // connection of the latest statement
+
+	conns  int
+	reuses int
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{seen: make(map[uintptr]bool), closed: make(map[uintptr]bool)}
+}
+
+// Connected counts a ConnectHook run: the next new handle is its connection.
+func (t *connTracker) Connected() {
+	t.fresh++
+}
+
+func (t *connTracker) Record(info sqlite3.TraceInfo) {
+	conn := info.ConnHandle
+	if t.closed[conn] {
+		delete(t.closed, conn)
+		delete(t.seen, conn)
+		if t.fresh == 0 {
+			log.Printf("connection 0x%x traced again after its close: ev %s stmt 0x%x\n",
+				conn, eventName(info.EventCode), info.StmtHandle)
+		}
+	}
+	switch info.EventCode {
+	case sqlite3.TraceStmt:
+		if conn == t.last {
+			return
+		}
+		if t.seen[conn] {
+			t.reuses++
+		} else {
+			t.seen[conn] = true
+			t.conns++
+			if t.fresh > 0 {
+				t.fresh--
+			}
+		}
+		t.last = conn
+	case sqlite3.TraceClose:
+		t.closed[conn] = true
+		if conn == t.last {
+			t.last = 0
+		}
+	}
+}
+
+// Reset forgets the connections used and the reuses, not the closed ones.
+func (t *connTracker) Reset() {
+	t.seen = make(map[uintptr]bool)
+	t.last = 0
+	t.conns, t.reuses = 0, 0
+}
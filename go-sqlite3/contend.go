@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// contend reproduces lock contention between two connections of db: the
+// first takes the write lock with BEGIN IMMEDIATE and holds it for hold,
+// the second asks for it right after and waits in SQLite's busy handler,
+// for up to the busy_timeout of its connection.
+//
+// In the trace the wait is the run and wall time of the second BEGIN
+// IMMEDIATE, which both include the sleeps of the busy handler. If hold
+// is past the busy_timeout, the BEGIN fails with SQLITE_BUSY, returned.
+// Nothing is written: both transactions are rolled back.
+func contend(ctx context.Context, db *sql.DB, hold time.Duration) error {
+	holder, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer holder.Close()
+	waiter, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer waiter.Close()
+
+	if _, err := holder.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return err
+	}
+	released := make(chan struct{})
+	go func() {
+		defer close(released)
+		select {
+		case <-time.After(hold):
+		case <-ctx.Done():
+		}
+		// Not ctx: a cancelled one would keep the lock.
+		if _, err := holder.ExecContext(context.Background(), "ROLLBACK"); err != nil {
+			log.Printf("contend: release got error: %s\n", err)
+		}
+	}()
+	defer func() { <-released }()
+
+	start := time.Now()
+	_, err = waiter.ExecContext(ctx, "BEGIN IMMEDIATE")
+	waited := time.Since(start)
+	if err != nil {
+		fmt.Printf("--------- contend: gave up after %s: %s\n", waited.Round(time.Millisecond), err)
+		return err
+	}
+	fmt.Printf("--------- contend: got the lock after %s\n", waited.Round(time.Millisecond))
+	_, err = waiter.ExecContext(context.Background(), "ROLLBACK")
+	return err
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// dedupWriter passes lines through to w, except a line equal to the one
+// before it: those are counted, and the count is written as
+// "... repeated Nx" before the next different line, or on Close.
+// Like the trace writers it expects one whole line per Write.
+type dedupWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	last     []byte
+	repeated int
+}
+
+func newDedupWriter(w io.Writer) *dedupWriter {
+	return &dedupWriter{w: w}
+}
+
+func (d *dedupWriter) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.last != nil && bytes.Equal(p, d.last) {
+		d.repeated++
+		return len(p), nil
+	}
+	if err := d.flushLocked(); err != nil {
+		return 0, err
+	}
+	d.last = append(d.last[:0], p...)
+	return d.w.Write(p)
+}
+
+func (d *dedupWriter) flushLocked() error {
+	if d.repeated == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(d.w, "... repeated %dx\n", d.repeated)
+	d.repeated = 0
+	return err
+}
+
+// Close writes the count of a repeat still pending; it does not close w.
+func (d *dedupWriter) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.flushLocked()
+}
@@ -0,0 +1,37 @@
+package main
+
+// handleIDs replaces the connection and statement handles of the trace
+// with small numbers, given out in the order the handles are first seen,
+// for --deterministic. A handle SQLite reuses keeps its number.
+type handleIDs struct {
+	conns map[uintptr]uintptr
+	stmts map[uintptr]uintptr
+}
+
+func newHandleIDs() *handleIDs {
+	return &handleIDs{
+		conns: make(map[uintptr]uintptr),
+		stmts: make(map[uintptr]uintptr),
+	}
+}
+
+func (h *handleIDs) conn(handle uintptr) uintptr {
+	return stableID(h.conns, handle)
+}
+
+func (h *handleIDs) stmt(handle uintptr) uintptr {
+	return stableID(h.stmts, handle)
+}
+
+// stableID returns the number of handle in ids; 0, no handle, stays 0.
+func stableID(ids map[uintptr]uintptr, handle uintptr) uintptr {
+	if handle == 0 {
+		return 0
+	}
+	id, ok := ids[handle]
+	if !ok {
+		id = uintptr(len(ids) + 1)
+		ids[handle] = id
+	}
+	return id
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// Error categories of classifyError.
+const (
+	errConstraint = "constraint"
+	errBusy       = "busy"
+	errIO         = "io"
+	errSyntax     = "syntax"
+	errSQL        = "sql" // any other SQLITE_ERROR: no such table or column, ...
+	errOther      = "other"
+)
+
+// classifyError sorts an error from the database into a category, and
+// tells whether running the same statement again may succeed. Errors that
+// do not come from SQLite, context cancellation for one, are "other".
+func classifyError(err error) (category string, retriable bool) {
+	var e sqlite3.Error
+	if !errors.As(err, &e) {
+		return errOther, false
+	}
+	switch e.Code {
+	case sqlite3.ErrConstraint:
+		return errConstraint, false
+	case sqlite3.ErrBusy, sqlite3.ErrLocked:
+		return errBusy, true
+	case sqlite3.ErrIoErr, sqlite3.ErrCorrupt, sqlite3.ErrFull, sqlite3.ErrCantOpen, sqlite3.ErrNotADB:
+		return errIO, false
+	case sqlite3.ErrError:
+		// SQLITE_ERROR is what a statement that does not parse fails
+		// with, but also one naming a table or column that does not
+		// exist; only the message of the parser tells them apart.
+		if isSyntaxError(e.Error()) {
+			return errSyntax, false
+		}
+		return errSQL, false
+	}
+	return errOther, false
+}
+
+// exitCodeFor maps the category of err to the exit code of dbMain,
+// so that a calling script can tell the failures apart.
+func exitCodeFor(err error) int {
+	category, _ := classifyError(err)
+	switch category {
+	case errConstraint:
+		return exitConstraint
+	case errBusy:
+		return exitBusy
+	case errIO:
+		return exitIO
+	case errSyntax:
+		return exitSyntax
+	case errSQL:
+		return exitSQL
+	}
+	return exitFailure
+}
+
+// isSyntaxError reports whether msg, the message of an SQLITE_ERROR, is
+// one of the parser's: `near "selec": syntax error`, and the like.
+func isSyntaxError(msg string) bool {
+	return strings.Contains(msg, "syntax error") || strings.HasPrefix(msg, `near "`) ||
+		msg == "incomplete input" || strings.HasPrefix(msg, "unrecognized token")
+}
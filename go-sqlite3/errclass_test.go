@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+func TestExitCodeFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		category string
+		code     int
+	}{
+		{"constraint", sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique}, errConstraint, exitConstraint},
+		{"busy", sqlite3.Error{Code: sqlite3.ErrBusy}, errBusy, exitBusy},
+		{"locked", sqlite3.Error{Code: sqlite3.ErrLocked}, errBusy, exitBusy},
+		{"io", sqlite3.Error{Code: sqlite3.ErrIoErr}, errIO, exitIO},
+		{"not a database", sqlite3.Error{Code: sqlite3.ErrNotADB}, errIO, exitIO},
+		{"syntax", sqliteErr(t, "selec 1"), errSyntax, exitSyntax},
+		{"incomplete", sqliteErr(t, "select ("), errSyntax, exitSyntax},
+		{"no such table", sqliteErr(t, "select * from missing"), errSQL, exitSQL},
+		{"no such column", sqliteErr(t, "select missing"), errSQL, exitSQL},
+		{"plain SQLITE_ERROR", sqlite3.Error{Code: sqlite3.ErrError}, errSQL, exitSQL},
+		{"wrapped", fmt.Errorf("query: %w", sqlite3.Error{Code: sqlite3.ErrBusy}), errBusy, exitBusy},
+		{"cancelled", context.Canceled, errOther, exitFailure},
+		{"not sqlite", sql.ErrNoRows, errOther, exitFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if category, _ := classifyError(tt.err); category != tt.category {
+				t.Errorf("classifyError = %q, want %q", category, tt.category)
+			}
+			if code := exitCodeFor(tt.err); code != tt.code {
+				t.Errorf("exitCodeFor = %d, want %d", code, tt.code)
+			}
+		})
+	}
+}
+
+// sqliteErr returns the error SQLite fails query with.
+func sqliteErr(t *testing.T, query string) error {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec(query)
+	if err == nil {
+		t.Fatalf("%q did not fail", query)
+	}
+	return err
+}
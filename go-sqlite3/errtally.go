@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// extendedCodeNames names the extended result codes a sample run is
+// likely to hit. Primary codes double as extended codes when SQLite has
+// nothing more specific to say.
+var extendedCodeNames = map[int]string{
+	int(sqlite3.ErrError):                "SQL error",
+	int(sqlite3.ErrAbort):                "aborted",
+	int(sqlite3.ErrBusy):                 "busy",
+	int(sqlite3.ErrLocked):               "locked",
+	int(sqlite3.ErrReadonly):             "readonly",
+	int(sqlite3.ErrInterrupt):            "interrupted",
+	int(sqlite3.ErrIoErr):                "I/O error",
+	int(sqlite3.ErrCorrupt):              "corrupt",
+	int(sqlite3.ErrCantOpen):             "cannot open",
+	int(sqlite3.ErrSchema):               "schema changed",
+	int(sqlite3.ErrConstraint):           "constraint",
+	int(sqlite3.ErrMismatch):             "datatype mismatch",
+	int(sqlite3.ErrMisuse):               "misuse",
+	int(sqlite3.ErrRange):                "bind index out of range",
+	int(sqlite3.ErrBusySnapshot):         "busy snapshot",
+	int(sqlite3.ErrLockedSharedCache):    "locked shared cache",
+	int(sqlite3.ErrReadonlyDbMoved):      "readonly, database moved",
+	int(sqlite3.ErrConstraintCheck):      "CHECK constraint",
+	int(sqlite3.ErrConstraintForeignKey): "FOREIGN KEY constraint",
+	int(sqlite3.ErrConstraintNotNull):    "NOT NULL constraint",
+	int(sqlite3.ErrConstraintPrimaryKey): "PRIMARY KEY constraint",
+	int(sqlite3.ErrConstraintTrigger):    "trigger constraint",
+	int(sqlite3.ErrConstraintUnique):     "UNIQUE constraint",
+	int(sqlite3.ErrConstraintRowID):      "rowid constraint",
+	int(sqlite3.ErrIoErrFsync):           "fsync I/O error",
+	int(sqlite3.ErrIoErrWrite):           "write I/O error",
+	int(sqlite3.ErrIoErrRead):            "read I/O error",
+	int(sqlite3.ErrIoErrShortRead):       "short read I/O error",
+}
+
+// errorTally counts the database errors of a run by extended code.
+//
+// It has no lock of its own, the TraceCollector serializes the calls.
+type errorTally struct {
+	counts map[int]int // ExtendedCode -> occurrences
+}
+
+func newErrorTally() *errorTally {
+	return &errorTally{counts: make(map[int]int)}
+}
+
+func (t *errorTally) Record(info sqlite3.TraceInfo) {
+	if isDBError(info.DBError) {
+		t.counts[int(info.DBError.ExtendedCode)]++
+	}
+}
+
+// Report writes one line per extended code, most frequent first,
+// and nothing when the run had no errors.
+func (t *errorTally) Report(w io.Writer) {
+	codes := make([]int, 0, len(t.counts))
+	for code := range t.counts {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		if t.counts[codes[i]] != t.counts[codes[j]] {
+			return t.counts[codes[i]] > t.counts[codes[j]]
+		}
+		return codes[i] < codes[j]
+	})
+	for _, code := range codes {
+		name, ok := extendedCodeNames[code]
+		if !ok {
+			name = "unknown"
+		}
+		fmt.Fprintf(w, "extended code %d (%s): %d occurrences\n", code, name, t.counts[code])
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// explainQuery prints SQLite's plan for query: the id, parent and detail
+// columns of EXPLAIN QUERY PLAN, run with the same bind values as the
+// query itself would be.
+func explainQuery(ctx context.Context, tx querier, w io.Writer, query string, args ...interface{}) error {
+	rows, err := tx.QueryContext(ctx, "EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	fmt.Fprintf(w, "--------- Plan: %s\n", normalizeSQL(query))
+	fmt.Fprintln(w, "id\tparent\tdetail")
+	for rows.Next() {
+		var (
+			id, parent, notUsed int
+			detail              string
+		)
+		if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\n", id, parent, detail)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return rows.Close()
+}
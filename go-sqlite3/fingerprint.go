@@ -0,0 +1,103 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// inListRe matches an IN list once its members are all '?'.
+var inListRe = regexp.MustCompile(`\bin ?\(\?(?:, \?)*\)`)
+
+// fingerprint reduces a statement to the shape it shares with every
+// other execution of the same query: unquoted words are lowercased,
+// comments dropped, whitespace collapsed, literals and bind parameters
+// replaced with '?', IN (...) lists folded to "in (?)" and a trailing
+// semicolon removed. Quoted identifiers are kept as they are.
+//
+// Two statements with the same fingerprint group together in the
+// aggregates, whatever values they ran with.
+func fingerprint(sql string) string {
+	var b strings.Builder
+	b.Grow(len(sql))
+	space := false // whitespace seen since the last token
+	emit := func(tok string) {
+		// No space inside parentheses or before a comma, so that
+		// "( ?,? )" and "(?, ?)" come out the same.
+		if space && b.Len() > 0 && tok != ")" && tok != "," && tok != ";" &&
+			!strings.HasSuffix(b.String(), "(") {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteString(tok)
+	}
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			space = true
+			i++
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			i += end
+			space = true
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end + 4
+			}
+			space = true
+		case c == '\'':
+			i = skipQuoted(sql, i, '\'')
+			emit("?")
+		case (c == 'x' || c == 'X') && i+1 < len(sql) && sql[i+1] == '\'' &&
+			(i == 0 || !isIdentByte(sql[i-1])):
+			i = skipQuoted(sql, i+1, '\'')
+			emit("?")
+		case c == '"' || c == '`':
+			end := skipQuoted(sql, i, c)
+			emit(sql[i:end])
+			i = end
+		case c == '[':
+			end := strings.IndexByte(sql[i:], ']')
+			if end < 0 {
+				end = len(sql)
+			} else {
+				end += i + 1
+			}
+			emit(sql[i:end])
+			i = end
+		case isDigit(c) || (c == '.' && i+1 < len(sql) && isDigit(sql[i+1])):
+			i = skipNumber(sql, i)
+			emit("?")
+		case c == '?' || ((c == ':' || c == '@' || c == '$') && i+1 < len(sql) && isIdentByte(sql[i+1])):
+			// ?, ?NNN, :name, @name and $name are all just a parameter
+			i++
+			for i < len(sql) && isIdentByte(sql[i]) {
+				i++
+			}
+			emit("?")
+		case isIdentByte(c):
+			start := i
+			for i < len(sql) && isIdentByte(sql[i]) {
+				i++
+			}
+			emit(strings.ToLower(sql[start:i]))
+		case c == ',':
+			emit(",")
+			space = true // always "a, b"
+			i++
+		default:
+			emit(string(c))
+			i++
+		}
+	}
+
+	fp := strings.TrimRight(b.String(), "; ")
+	return inListRe.ReplaceAllString(fp, "in (?)")
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		name, sql, want string
+	}{
+		{"sample join", tokenSQL,
+			"select token, user_id, device_id from token as t inner join (select id from user where user_name = ?) as u on u.id = t.user_id"},
+		{"keywords and whitespace", "SELECT  *\n\tFROM   user\nWHERE id = ?;",
+			"select * from user where id = ?"},
+		{"string literal", "select * from user where user_name = 'O''Brien'",
+			"select * from user where user_name = ?"},
+		{"numbers", "select 1, -2.5, 3e10, 0x1F, .5",
+			"select ?, -?, ?, ?, ?"},
+		{"blob", "insert into t values (x'00ff', X'AB')",
+			"insert into t values (?, ?)"},
+		{"x ending an identifier", "select max'a' from t",
+			"select max? from t"},
+		{"x after a number", "select 12x'00'",
+			"select ?x?"},
+		{"parameters", "select * from t where a = ?1 and b = :b and c = @c and d = $d",
+			"select * from t where a = ? and b = ? and c = ? and d = ?"},
+		{"in list", "select * from t where id IN (1, 2,3)",
+			"select * from t where id in (?)"},
+		{"in list of parameters", "select * from t where id in ( ?,?, ? )",
+			"select * from t where id in (?)"},
+		{"line comment", "select 1 -- the answer\nfrom t",
+			"select ? from t"},
+		{"block comment", "select /* all */ * from t",
+			"select * from t"},
+		{"quoted identifiers", `select "User Name", [Id], ` + "`Key`" + ` from t`,
+			`select "User Name", [Id], ` + "`Key`" + ` from t`},
+		{"multi-line", "\nCREATE TABLE IF NOT EXISTS user (\n id INTEGER PRIMARY KEY AUTOINCREMENT,\n user_name TEXT NOT NULL\n);",
+			"create table if not exists user (id integer primary key autoincrement, user_name text not null)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fingerprint(tt.sql); got != tt.want {
+				t.Errorf("fingerprint(%q)\n got %q\nwant %q", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// TraceFormatter turns a trace event into the line the collector writes,
+// newline included. skip drops the event instead; filtering on the event
+// itself is the collector's job, this is for formats that cannot render it.
+type TraceFormatter interface {
+	Format(info sqlite3.TraceInfo) (line string, skip bool)
+}
+
+// redactionFormatter is a TraceFormatter that marks an ExpandedSQL the
+// collector redacted, where a line would otherwise look as though nothing
+// had been bound. The collector calls FormatRedacted for those events.
+type redactionFormatter interface {
+	TraceFormatter
+	FormatRedacted(info sqlite3.TraceInfo) (line string, skip bool)
+}
+
+// writeVerbose writes the whole TraceInfo in Go syntax, for the line of
+// the event that follows it. Not very readable, but it shows every field
+// as the driver filled it in, which helps in case of doubt. It is a line
+// of its own, in format, so that the event line stays whole; the JSON
+// formats carry the dump as a string.
+func writeVerbose(w io.Writer, format string, info sqlite3.TraceInfo) {
+	dump := fmt.Sprintf("%#v", info)
+	switch format {
+	case "text":
+		fmt.Fprintf(w, "Trace: %s\n", dump)
+		return
+	case "logfmt":
+		io.WriteString(w, logfmtLine("event", "verbose", "trace_info", dump))
+		return
+	}
+	line, _ := json.Marshal(struct {
+		Event     string `json:"event"`
+		TraceInfo string `json:"trace_info"`
+	}{"verbose", dump})
+	w.Write(append(line, '\n'))
+}
+
+// newTraceFormatter returns the TraceFormatter for a --trace-format name.
+func newTraceFormatter(name string) (TraceFormatter, error) {
+	switch name {
+	case "text":
+		return TextFormatter{}, nil
+	case "json":
+		return JSONFormatter{}, nil
+	case "logfmt":
+		return LogfmtFormatter{}, nil
+	case "otlp-log":
+		return OTLPLogFormatter{}, nil
+	case "chrome":
+		return newChromeFormatter(), nil
+	case "ndjson":
+		return NDJSONFormatter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown --trace-format %q, want text, json, logfmt, otlp-log, chrome or ndjson", name)
+	}
+}
+
+// isJSONFormat reports whether the lines of a --trace-format are JSON
+// objects, which the fields added to a line must go into.
+func isJSONFormat(name string) bool {
+	return name == "json" || name == "otlp-log" || name == "chrome"
+}
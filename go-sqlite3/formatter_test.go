@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// stopClock makes sinceStart 0 for the rest of t, so that the lines
+// carry t=+0.000s.
+func stopClock(t *testing.T) {
+	saved := fixedClock
+	fixedClock = true
+	t.Cleanup(func() { fixedClock = saved })
+}
+
+func TestTextFormatter(t *testing.T) {
+	stopClock(t)
+	tests := []struct {
+		name string
+		info sqlite3.TraceInfo
+		want string
+	}{
+		{"stmt expanded unchanged", sqlite3.TraceInfo{
+			EventCode: sqlite3.TraceStmt, AutoCommit: true, ConnHandle: 0x10, StmtHandle: 0x20,
+			StmtOrTrigger: "select 1", ExpandedSQL: "select 1",
+		}, `Trace: t=+0.000s ev stmt -AC- conn 0x10, stmt 0x20 {"select 1"} = exp.` + "\n"},
+		{"stmt expanded", sqlite3.TraceInfo{
+			EventCode: sqlite3.TraceStmt, ConnHandle: 0x10, StmtHandle: 0x20,
+			StmtOrTrigger: "select ?", ExpandedSQL: "select 'alice'",
+		}, `Trace: t=+0.000s ev stmt +Tx+ conn 0x10, stmt 0x20 {"select ?"} expanded {"select 'alice'"}.` + "\n"},
+		{"profile ms", sqlite3.TraceInfo{
+			EventCode: sqlite3.TraceProfile, AutoCommit: true, ConnHandle: 0x10, StmtHandle: 0x20,
+			RunTimeNanosec: 3000000,
+		}, `Trace: t=+0.000s ev profile -AC- conn 0x10, stmt 0x20 {""}; time 3 ms.` + "\n"},
+		{"profile sub-millisecond", sqlite3.TraceInfo{
+			EventCode: sqlite3.TraceProfile, AutoCommit: true, ConnHandle: 0x10, StmtHandle: 0x20,
+			RunTimeNanosec: 1234567,
+		}, `Trace: t=+0.000s ev profile -AC- conn 0x10, stmt 0x20 {""}; time 1234567 ns!!!.` + "\n"},
+		{"profile no time", sqlite3.TraceInfo{
+			EventCode: sqlite3.TraceProfile, AutoCommit: true, ConnHandle: 0x10, StmtHandle: 0x20,
+		}, `Trace: t=+0.000s ev profile -AC- conn 0x10, stmt 0x20 {""}; time 0.` + "\n"},
+		{"profile DB error", sqlite3.TraceInfo{
+			EventCode: sqlite3.TraceProfile, AutoCommit: true, ConnHandle: 0x10, StmtHandle: 0x20,
+			DBError: sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique},
+		}, `Trace: t=+0.000s ev profile -AC- conn 0x10, stmt 0x20 {""}; time 0; DB error: sqlite3.Error{Code:19, ExtendedCode:2067, SystemErrno:0x0, err:""}` + "\n"},
+		{"profile SQLITE_ROW", sqlite3.TraceInfo{
+			EventCode: sqlite3.TraceProfile, AutoCommit: true, ConnHandle: 0x10, StmtHandle: 0x20,
+			DBError: sqlite3.Error{Code: sqliteRow, ExtendedCode: sqliteRow},
+		}, `Trace: t=+0.000s ev profile -AC- conn 0x10, stmt 0x20 {""}; time 0.` + "\n"},
+		{"row", sqlite3.TraceInfo{
+			EventCode: sqlite3.TraceRow, AutoCommit: true, ConnHandle: 0x10, StmtHandle: 0x20,
+		}, `Trace: t=+0.000s ev row -AC- conn 0x10, stmt 0x20 {""}.` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line, skip := TextFormatter{}.Format(tt.info)
+			if skip {
+				t.Fatal("skipped")
+			}
+			if line != tt.want {
+				t.Errorf("\n got %q\nwant %q", line, tt.want)
+			}
+		})
+	}
+}
+
+// TestCollectorWritesText feeds synthetic events to the collector and
+// checks the lines it writes to its writer.
+func TestCollectorWritesText(t *testing.T) {
+	stopClock(t)
+	var out bytes.Buffer
+	c := newTestCollector(&out)
+	c.Callback(sqlite3.TraceInfo{
+		EventCode: sqlite3.TraceStmt, AutoCommit: true, ConnHandle: 0x10, StmtHandle: 0x20,
+		StmtOrTrigger: "select 1", ExpandedSQL: "select 1",
+	})
+	c.Callback(sqlite3.TraceInfo{
+		EventCode: sqlite3.TraceProfile, AutoCommit: true, ConnHandle: 0x10, StmtHandle: 0x20,
+		RunTimeNanosec: 2000000,
+	})
+	for _, want := range []string{
+		`Trace: t=+0.000s ev stmt -AC- conn 0x10, stmt 0x20 {"select 1"} = exp.` + "\n",
+		`Trace: t=+0.000s ev profile -AC- conn 0x10, stmt 0x20 {""}; time 2 ms.` + "\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output has no %q:\n%s", want, out.String())
+		}
+	}
+}
+
+// TestCollectorRedacts checks that a redacted statement is marked as
+// such, not printed as " = exp" as though nothing had been bound.
+func TestCollectorRedacts(t *testing.T) {
+	stopClock(t)
+	stmt := sqlite3.TraceInfo{
+		EventCode: sqlite3.TraceStmt, AutoCommit: true, ConnHandle: 0x10, StmtHandle: 0x20,
+		StmtOrTrigger: "select ?", ExpandedSQL: "select 'alice'",
+	}
+	tests := []struct {
+		name     string
+		template string
+		redact   bool
+		want     string
+	}{
+		{"redacted", defaultTextTemplate, true,
+			`Trace: t=+0.000s ev stmt -AC- conn 0x10, stmt 0x20 {"select ?"} = <redacted>. params=1 (1 str)` + "\n"},
+		{"not redacted", defaultTextTemplate, false,
+			`Trace: t=+0.000s ev stmt -AC- conn 0x10, stmt 0x20 {"select ?"} expanded {"select 'alice'"}. params=1 (1 str)` + "\n"},
+		{"template", "{{.Redacted}} {{.ExpandedSQL}}", true, "true select ? params=1 (1 str)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			c := newTraceCollector(traceSettings{
+				out:        &out,
+				format:     "text",
+				template:   tt.template,
+				sampleRate: 1,
+				redact:     tt.redact,
+			})
+			c.Callback(stmt)
+			if out.String() != tt.want {
+				t.Errorf("\n got %q\nwant %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectorWallTime(t *testing.T) {
+	for _, wallTime := range []bool{false, true} {
+		var out bytes.Buffer
+		c := newTraceCollector(traceSettings{
+			out:        &out,
+			format:     "text",
+			template:   defaultTextTemplate,
+			sampleRate: 1,
+			wallTime:   wallTime,
+		})
+		c.Callback(sqlite3.TraceInfo{EventCode: sqlite3.TraceStmt, ConnHandle: 0x10, StmtHandle: 0x20, StmtOrTrigger: "select 1"})
+		c.Callback(sqlite3.TraceInfo{EventCode: sqlite3.TraceProfile, ConnHandle: 0x10, StmtHandle: 0x20, RunTimeNanosec: 1000000})
+		if got := strings.Contains(out.String(), "Trace: stmt 0x20 run_ns 1000000 wall_ns "); got != wallTime {
+			t.Errorf("wallTime %t: wall time line written %t:\n%s", wallTime, got, out.String())
+		}
+	}
+}
+
+func TestCollectorRowCounts(t *testing.T) {
+	for _, rowCounts := range []bool{false, true} {
+		var out bytes.Buffer
+		c := newTraceCollector(traceSettings{
+			out:        &out,
+			format:     "text",
+			template:   defaultTextTemplate,
+			sampleRate: 1,
+			rowCounts:  rowCounts,
+		})
+		c.Callback(sqlite3.TraceInfo{EventCode: sqlite3.TraceStmt, ConnHandle: 0x10, StmtHandle: 0x20, StmtOrTrigger: "select 1"})
+		c.Callback(sqlite3.TraceInfo{EventCode: sqlite3.TraceRow, ConnHandle: 0x10, StmtHandle: 0x20})
+		c.Callback(sqlite3.TraceInfo{EventCode: sqlite3.TraceRow, ConnHandle: 0x10, StmtHandle: 0x20})
+		c.Callback(sqlite3.TraceInfo{EventCode: sqlite3.TraceProfile, ConnHandle: 0x10, StmtHandle: 0x20})
+		if got := strings.Contains(out.String(), "Trace: stmt 0x20 2 rows scanned\n"); got != rowCounts {
+			t.Errorf("rowCounts %t: row count line written %t:\n%s", rowCounts, got, out.String())
+		}
+	}
+}
+
+// TestCollectorVerbose checks that the dump of --verbose-trace is a line
+// of its own, and in the JSON formats a JSON object like the others.
+func TestCollectorVerbose(t *testing.T) {
+	stopClock(t)
+	stmt := sqlite3.TraceInfo{
+		EventCode: sqlite3.TraceStmt, AutoCommit: true, ConnHandle: 0x10, StmtHandle: 0x20,
+		StmtOrTrigger: "select ?, ?", ExpandedSQL: "select 'a', 'b'",
+	}
+	for _, format := range []string{"text", "logfmt", "json", "otlp-log"} {
+		t.Run(format, func(t *testing.T) {
+			var out bytes.Buffer
+			c := newTraceCollector(traceSettings{
+				out:        &out,
+				format:     format,
+				template:   defaultTextTemplate,
+				sampleRate: 1,
+				verbose:    true,
+			})
+			c.Callback(stmt)
+			lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+			if len(lines) != 2 {
+				t.Fatalf("%d lines, want the dump and the event:\n%s", len(lines), out.String())
+			}
+			if !strings.Contains(lines[0], "sqlite3.TraceInfo{") {
+				t.Errorf("first line is no dump: %s", lines[0])
+			}
+			if strings.Contains(lines[1], "sqlite3.TraceInfo{") {
+				t.Errorf("the event line has the dump in it: %s", lines[1])
+			}
+			if isJSONFormat(format) {
+				for _, line := range lines {
+					if !json.Valid([]byte(line)) {
+						t.Errorf("not JSON: %s", line)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestLogfmtFormatterDBError checks that SQLITE_ROW and SQLITE_DONE, the
+// codes of a statement that went fine, are no err on the line.
+func TestLogfmtFormatterDBError(t *testing.T) {
+	stopClock(t)
+	tests := []struct {
+		name    string
+		err     sqlite3.Error
+		wantErr bool
+	}{
+		{"none", sqlite3.Error{}, false},
+		{"row", sqlite3.Error{Code: sqliteRow, ExtendedCode: sqliteRow}, false},
+		{"done", sqlite3.Error{Code: sqliteDone, ExtendedCode: sqliteDone}, false},
+		{"constraint", sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line, _ := LogfmtFormatter{}.Format(sqlite3.TraceInfo{
+				EventCode: sqlite3.TraceProfile, ConnHandle: 0x10, StmtHandle: 0x20, DBError: tt.err,
+			})
+			if got := strings.Contains(line, " err_code="); got != tt.wantErr {
+				t.Errorf("err_code on the line %t, want %t: %s", got, tt.wantErr, line)
+			}
+		})
+	}
+}
+
+// TestJSONFormatterDBError checks that db_error is null but for a
+// failed statement, not filled in with SQLITE_ROW or SQLITE_DONE.
+func TestJSONFormatterDBError(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     sqlite3.Error
+		wantErr bool
+	}{
+		{"none", sqlite3.Error{}, false},
+		{"row", sqlite3.Error{Code: sqliteRow, ExtendedCode: sqliteRow}, false},
+		{"done", sqlite3.Error{Code: sqliteDone, ExtendedCode: sqliteDone}, false},
+		{"constraint", sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line, _ := JSONFormatter{}.Format(sqlite3.TraceInfo{
+				EventCode: sqlite3.TraceProfile, ConnHandle: 0x10, StmtHandle: 0x20, DBError: tt.err,
+			})
+			var ev jsonTraceEvent
+			if err := json.Unmarshal([]byte(line), &ev); err != nil {
+				t.Fatal(err)
+			}
+			if got := ev.DBError != nil; got != tt.wantErr {
+				t.Errorf("db_error set %t, want %t: %s", got, tt.wantErr, line)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// goid returns the id of the calling goroutine, for --debug-goid.
+//
+// Go has no API for it on purpose; this parses the "goroutine N [...]"
+// header of runtime.Stack, which is best effort, costs a stack dump per
+// call and is only meant for debugging which goroutine, through cgo,
+// ran a trace callback. It returns 0 if the header cannot be parsed.
+func goid() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+// appendGoid adds the goroutine id to a formatted trace line.
+func appendGoid(format, line string, id uint64) string {
+	if isJSONFormat(format) {
+		return `{"goid":` + strconv.FormatUint(id, 10) + "," + strings.TrimPrefix(line, "{")
+	}
+	return strings.TrimSuffix(line, "\n") + " g=" + strconv.FormatUint(id, 10) + "\n"
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// hourBuckets counts the statements by the hour of the day their profile
+// arrived, in loc, with the sum of their run times: over a day-long run
+// it shows whether the slow hours are the busy ones.
+//
+// Like rowCounter it has no lock, the TraceCollector serializes the calls.
+type hourBuckets struct {
+	loc   *time.Location
+	count [24]int
+	sum   [24]time.Duration
+}
+
+func newHourBuckets(loc *time.Location) *hourBuckets {
+	if loc == nil {
+		loc = time.Local
+	}
+	return &hourBuckets{loc: loc}
+}
+
+func (b *hourBuckets) Record(info sqlite3.TraceInfo) {
+	if info.EventCode != sqlite3.TraceProfile {
+		return
+	}
+	h := time.Now().In(b.loc).Hour()
+	b.count[h]++
+	b.sum[h] += time.Duration(info.RunTimeNanosec)
+}
+
+// hourStat is one hour of hourBuckets, as /hours of the admin server
+// serves it.
+type hourStat struct {
+	Hour  int   `json:"hour"`
+	Count int   `json:"count"`
+	SumNs int64 `json:"sum_ns"`
+}
+
+// stats returns the hours that saw a statement, in order.
+func (b *hourBuckets) stats() []hourStat {
+	var s []hourStat
+	for h := range b.count {
+		if b.count[h] > 0 {
+			s = append(s, hourStat{Hour: h, Count: b.count[h], SumNs: b.sum[h].Nanoseconds()})
+		}
+	}
+	return s
+}
+
+// Report writes the hours that saw a statement as a table.
+func (b *hourBuckets) Report(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "hour (%s)\tcount\tsum\tavg\n", b.loc)
+	for _, s := range b.stats() {
+		sum := time.Duration(s.SumNs)
+		fmt.Fprintf(tw, "%02d:00\t%d\t%s\t%s\n", s.Hour, s.Count, sum, sum/time.Duration(s.Count))
+	}
+	tw.Flush()
+}
+
+// Hours returns the statements by hour of the day so far.
+func (c *TraceCollector) Hours() []hourStat {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.hours.stats()
+}
+
+// HourlyReport writes the statements by hour of the day, for --hourly.
+func (c *TraceCollector) HourlyReport(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hours.Report(w)
+}
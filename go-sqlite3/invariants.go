@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+type stmtRun struct {
+	conn uintptr
+	rows int
+}
+
+// InvariantChecker verifies the order go-sqlite3 delivers events in:
+// per StmtHandle stmt, any number of rows, then profile; a TraceClose
+// only once the statements of its connection have finished. Every
+// violation is logged and counted.
+//
+// Trigger programs report their own TraceStmt ("-- TRIGGER name") in the
+// middle of the statement that fired them, so those are let through.
+//
+// Like rowCounter it has no lock, the TraceCollector serializes the calls.
+type InvariantChecker struct {
+	running    map[uintptr]stmtRun // StmtHandle -> statement between stmt and profile
+	violations int
+}
+
+func newInvariantChecker() *InvariantChecker {
+	return &InvariantChecker{running: make(map[uintptr]stmtRun)}
+}
+
+func (c *InvariantChecker) Record(info sqlite3.TraceInfo) {
+	run, ok := c.running[info.StmtHandle]
+	switch info.EventCode {
+	case sqlite3.TraceStmt:
+		if ok && !isTrigger(info.StmtOrTrigger) {
+			c.violate(info, "stmt while the previous run of the handle has not finished")
+		}
+		if !ok {
+			c.running[info.StmtHandle] = stmtRun{conn: info.ConnHandle}
+		}
+	case sqlite3.TraceRow:
+		if !ok {
+			c.violate(info, "row without a preceding stmt")
+			return
+		}
+		run.rows++
+		c.running[info.StmtHandle] = run
+	case sqlite3.TraceProfile:
+		if !ok {
+			c.violate(info, "profile without a preceding stmt")
+			return
+		}
+		delete(c.running, info.StmtHandle)
+	case sqlite3.TraceClose:
+		for handle, run := range c.running {
+			if run.conn == info.ConnHandle {
+				c.violate(sqlite3.TraceInfo{EventCode: info.EventCode, ConnHandle: info.ConnHandle, StmtHandle: handle},
+					fmt.Sprintf("close while a statement is running (%d rows, no profile)", run.rows))
+				delete(c.running, handle)
+			}
+		}
+	}
+}
+
+func (c *InvariantChecker) violate(info sqlite3.TraceInfo, what string) {
+	c.violations++
+	log.Printf("trace invariant violated: %s: ev %s conn 0x%x stmt 0x%x\n",
+		what, eventName(info.EventCode), info.ConnHandle, info.StmtHandle)
+}
+
+// Violations returns how many violations have been seen.
+func (c *InvariantChecker) Violations() int {
+	return c.violations
+}
+
+func isTrigger(stmtOrTrigger string) bool {
+	return len(stmtOrTrigger) >= 2 && stmtOrTrigger[:2] == "--"
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+func TestInvariantChecker(t *testing.T) {
+	const conn, other = 0x10, 0x20
+	ev := func(code uint32, conn, stmt uintptr, sql string) sqlite3.TraceInfo {
+		return sqlite3.TraceInfo{EventCode: code, ConnHandle: conn, StmtHandle: stmt, StmtOrTrigger: sql}
+	}
+	stmt := func(h uintptr) sqlite3.TraceInfo { return ev(sqlite3.TraceStmt, conn, h, "select 1") }
+	row := func(h uintptr) sqlite3.TraceInfo { return ev(sqlite3.TraceRow, conn, h, "") }
+	profile := func(h uintptr) sqlite3.TraceInfo { return ev(sqlite3.TraceProfile, conn, h, "select 1") }
+	closeConn := func(c uintptr) sqlite3.TraceInfo { return ev(sqlite3.TraceClose, c, 0, "") }
+
+	tests := []struct {
+		name       string
+		events     []sqlite3.TraceInfo
+		violations int
+	}{
+		{"in order", []sqlite3.TraceInfo{stmt(1), row(1), row(1), profile(1), stmt(1), profile(1), closeConn(conn)}, 0},
+		{"interleaved handles", []sqlite3.TraceInfo{stmt(1), stmt(2), row(2), row(1), profile(2), profile(1)}, 0},
+		{"trigger inside a statement", []sqlite3.TraceInfo{stmt(1), ev(sqlite3.TraceStmt, conn, 1, "-- TRIGGER audit"), profile(1)}, 0},
+		{"profile without stmt", []sqlite3.TraceInfo{profile(1)}, 1},
+		{"row without stmt", []sqlite3.TraceInfo{row(1), stmt(1), profile(1)}, 1},
+		{"row after profile", []sqlite3.TraceInfo{stmt(1), profile(1), row(1)}, 1},
+		{"stmt twice", []sqlite3.TraceInfo{stmt(1), stmt(1), profile(1)}, 1},
+		{"close before profile", []sqlite3.TraceInfo{stmt(1), stmt(2), row(1), closeConn(conn), profile(1), profile(2)}, 4},
+		{"close of another connection", []sqlite3.TraceInfo{stmt(1), closeConn(other), profile(1)}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newInvariantChecker()
+			for _, info := range tt.events {
+				c.Record(info)
+			}
+			if got := c.Violations(); got != tt.violations {
+				t.Errorf("%d violations, want %d", got, tt.violations)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+//go:build io_accounting
+
+package main
+
+/*
+// The SQLite go-sqlite3 links in has the ABI of any other, so the system
+// header serves to build the shim against it; it is only needed with the
+// io_accounting tag.
+#include <sqlite3.h>
+#include <string.h>
+
+static sqlite3_vfs *baseVFS;
+static sqlite3_vfs countingVFS;
+static sqlite3_int64 readBytes, writeBytes;
+
+// A counting file is the base VFS's file right behind our header.
+typedef struct {
+	sqlite3_file base;
+	sqlite3_file *real;
+} countingFile;
+
+#define REAL(f) (((countingFile *)(f))->real)
+
+static int cClose(sqlite3_file *f) {
+	return REAL(f)->pMethods->xClose(REAL(f));
+}
+static int cRead(sqlite3_file *f, void *buf, int n, sqlite3_int64 off) {
+	__atomic_fetch_add(&readBytes, n, __ATOMIC_RELAXED);
+	return REAL(f)->pMethods->xRead(REAL(f), buf, n, off);
+}
+static int cWrite(sqlite3_file *f, const void *buf, int n, sqlite3_int64 off) {
+	__atomic_fetch_add(&writeBytes, n, __ATOMIC_RELAXED);
+	return REAL(f)->pMethods->xWrite(REAL(f), buf, n, off);
+}
+static int cTruncate(sqlite3_file *f, sqlite3_int64 size) {
+	return REAL(f)->pMethods->xTruncate(REAL(f), size);
+}
+static int cSync(sqlite3_file *f, int flags) {
+	return REAL(f)->pMethods->xSync(REAL(f), flags);
+}
+static int cFileSize(sqlite3_file *f, sqlite3_int64 *size) {
+	return REAL(f)->pMethods->xFileSize(REAL(f), size);
+}
+static int cLock(sqlite3_file *f, int lock) {
+	return REAL(f)->pMethods->xLock(REAL(f), lock);
+}
+static int cUnlock(sqlite3_file *f, int lock) {
+	return REAL(f)->pMethods->xUnlock(REAL(f), lock);
+}
+static int cCheckReservedLock(sqlite3_file *f, int *out) {
+	return REAL(f)->pMethods->xCheckReservedLock(REAL(f), out);
+}
+static int cFileControl(sqlite3_file *f, int op, void *arg) {
+	return REAL(f)->pMethods->xFileControl(REAL(f), op, arg);
+}
+static int cSectorSize(sqlite3_file *f) {
+	return REAL(f)->pMethods->xSectorSize(REAL(f));
+}
+static int cDeviceCharacteristics(sqlite3_file *f) {
+	return REAL(f)->pMethods->xDeviceCharacteristics(REAL(f));
+}
+static int cShmMap(sqlite3_file *f, int region, int size, int extend, void volatile **p) {
+	return REAL(f)->pMethods->xShmMap(REAL(f), region, size, extend, p);
+}
+static int cShmLock(sqlite3_file *f, int offset, int n, int flags) {
+	return REAL(f)->pMethods->xShmLock(REAL(f), offset, n, flags);
+}
+static void cShmBarrier(sqlite3_file *f) {
+	REAL(f)->pMethods->xShmBarrier(REAL(f));
+}
+static int cShmUnmap(sqlite3_file *f, int deleteFlag) {
+	return REAL(f)->pMethods->xShmUnmap(REAL(f), deleteFlag);
+}
+static int cFetch(sqlite3_file *f, sqlite3_int64 off, int n, void **p) {
+	return REAL(f)->pMethods->xFetch(REAL(f), off, n, p);
+}
+static int cUnfetch(sqlite3_file *f, sqlite3_int64 off, void *p) {
+	return REAL(f)->pMethods->xUnfetch(REAL(f), off, p);
+}
+
+// One set of methods per version of the base file's, so that SQLite
+// sees the same capabilities through the shim, e.g. no WAL without xShmMap.
+static const sqlite3_io_methods countingIO[3] = {
+	{1, cClose, cRead, cWrite, cTruncate, cSync, cFileSize, cLock, cUnlock,
+		cCheckReservedLock, cFileControl, cSectorSize, cDeviceCharacteristics},
+	{2, cClose, cRead, cWrite, cTruncate, cSync, cFileSize, cLock, cUnlock,
+		cCheckReservedLock, cFileControl, cSectorSize, cDeviceCharacteristics,
+		cShmMap, cShmLock, cShmBarrier, cShmUnmap},
+	{3, cClose, cRead, cWrite, cTruncate, cSync, cFileSize, cLock, cUnlock,
+		cCheckReservedLock, cFileControl, cSectorSize, cDeviceCharacteristics,
+		cShmMap, cShmLock, cShmBarrier, cShmUnmap, cFetch, cUnfetch},
+};
+
+static int cOpen(sqlite3_vfs *vfs, sqlite3_filename name, sqlite3_file *f, int flags, int *outFlags) {
+	countingFile *p = (countingFile *)f;
+	p->real = (sqlite3_file *)&p[1];
+	int rc = baseVFS->xOpen(baseVFS, name, p->real, flags, outFlags);
+	// Set even on failure: SQLite closes a file that has methods.
+	const sqlite3_io_methods *m = p->real->pMethods;
+	if (m == NULL) {
+		f->pMethods = NULL;
+	} else {
+		int v = m->iVersion < 1 ? 1 : m->iVersion > 3 ? 3 : m->iVersion;
+		f->pMethods = &countingIO[v - 1];
+	}
+	return rc;
+}
+
+// The VFS methods go to the base VFS, with the base VFS as argument.
+static int cDelete(sqlite3_vfs *vfs, const char *name, int syncDir) {
+	return baseVFS->xDelete(baseVFS, name, syncDir);
+}
+static int cAccess(sqlite3_vfs *vfs, const char *name, int flags, int *out) {
+	return baseVFS->xAccess(baseVFS, name, flags, out);
+}
+static int cFullPathname(sqlite3_vfs *vfs, const char *name, int n, char *out) {
+	return baseVFS->xFullPathname(baseVFS, name, n, out);
+}
+static void *cDlOpen(sqlite3_vfs *vfs, const char *name) {
+	return baseVFS->xDlOpen(baseVFS, name);
+}
+static void cDlError(sqlite3_vfs *vfs, int n, char *msg) {
+	baseVFS->xDlError(baseVFS, n, msg);
+}
+static void (*cDlSym(sqlite3_vfs *vfs, void *h, const char *sym))(void) {
+	return baseVFS->xDlSym(baseVFS, h, sym);
+}
+static void cDlClose(sqlite3_vfs *vfs, void *h) {
+	baseVFS->xDlClose(baseVFS, h);
+}
+static int cRandomness(sqlite3_vfs *vfs, int n, char *out) {
+	return baseVFS->xRandomness(baseVFS, n, out);
+}
+static int cSleep(sqlite3_vfs *vfs, int us) {
+	return baseVFS->xSleep(baseVFS, us);
+}
+static int cCurrentTime(sqlite3_vfs *vfs, double *out) {
+	return baseVFS->xCurrentTime(baseVFS, out);
+}
+static int cGetLastError(sqlite3_vfs *vfs, int n, char *msg) {
+	return baseVFS->xGetLastError ? baseVFS->xGetLastError(baseVFS, n, msg) : 0;
+}
+static int cCurrentTimeInt64(sqlite3_vfs *vfs, sqlite3_int64 *out) {
+	return baseVFS->xCurrentTimeInt64(baseVFS, out);
+}
+
+static int registerCountingVFS(const char *name) {
+	baseVFS = sqlite3_vfs_find(NULL);
+	if (baseVFS == NULL) {
+		return SQLITE_ERROR;
+	}
+	memset(&countingVFS, 0, sizeof countingVFS);
+	// Version 2 at most: the system call hooks of version 3 are left out.
+	countingVFS.iVersion = baseVFS->iVersion < 2 ? baseVFS->iVersion : 2;
+	countingVFS.szOsFile = sizeof(countingFile) + baseVFS->szOsFile;
+	countingVFS.mxPathname = baseVFS->mxPathname;
+	countingVFS.zName = name;
+	countingVFS.xOpen = cOpen;
+	countingVFS.xDelete = cDelete;
+	countingVFS.xAccess = cAccess;
+	countingVFS.xFullPathname = cFullPathname;
+	countingVFS.xDlOpen = cDlOpen;
+	countingVFS.xDlError = cDlError;
+	countingVFS.xDlSym = cDlSym;
+	countingVFS.xDlClose = cDlClose;
+	countingVFS.xRandomness = cRandomness;
+	countingVFS.xSleep = cSleep;
+	countingVFS.xCurrentTime = cCurrentTime;
+	countingVFS.xGetLastError = cGetLastError;
+	if (countingVFS.iVersion >= 2) {
+		countingVFS.xCurrentTimeInt64 = cCurrentTimeInt64;
+	}
+	return sqlite3_vfs_register(&countingVFS, 0);
+}
+
+static void countedBytes(sqlite3_int64 *read, sqlite3_int64 *write) {
+	*read = __atomic_load_n(&readBytes, __ATOMIC_RELAXED);
+	*write = __atomic_load_n(&writeBytes, __ATOMIC_RELAXED);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"sync"
+)
+
+var registerVFSOnce struct {
+	sync.Once
+	err error
+}
+
+// ioAccountingBuilt reports whether --io-accounting has its VFS.
+const ioAccountingBuilt = true
+
+// registerCountingVFS registers the "counting" VFS, the default VFS with
+// its xRead and xWrite sizes added up, for "vfs=counting" in the DSN.
+func registerCountingVFS() error {
+	registerVFSOnce.Do(func() {
+		// Never freed: SQLite keeps the name for as long as the VFS.
+		if rc := C.registerCountingVFS(C.CString(countingVFSName)); rc != C.SQLITE_OK {
+			registerVFSOnce.err = fmt.Errorf("register the %s VFS: error %d", countingVFSName, int(rc))
+		}
+	})
+	return registerVFSOnce.err
+}
+
+// countedBytes returns the bytes the counting VFS read and wrote so far.
+func countedBytes() ioBytes {
+	var read, write C.sqlite3_int64
+	C.countedBytes(&read, &write)
+	return ioBytes{read: int64(read), write: int64(write)}
+}
@@ -0,0 +1,18 @@
+//go:build !io_accounting
+
+package main
+
+import "errors"
+
+// ioAccountingBuilt reports whether --io-accounting has its VFS.
+const ioAccountingBuilt = false
+
+// registerCountingVFS needs the C shim of iovfs_cgo.go, which is only
+// built with the io_accounting tag.
+func registerCountingVFS() error {
+	return errors.New("--io-accounting needs a build with -tags io_accounting")
+}
+
+func countedBytes() ioBytes {
+	return ioBytes{}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// errBadKey marks a connection whose database --key could not open.
+var errBadKey = errors.New("database key rejected")
+
+// applyKey sends the encryption key to an SQLCipher (or SEE) build of
+// SQLite. It must be the first statement on conn and run before SetTrace:
+// PRAGMA takes no bind parameters, so the key is part of the text and would
+// otherwise end up in the trace. Stock SQLite ignores the pragma.
+func applyKey(conn *sqlite3.SQLiteConn, key string) error {
+	_, err := conn.Exec("PRAGMA key = '"+strings.ReplaceAll(key, "'", "''")+"'", nil)
+	return err
+}
+
+// verifyKey reads the schema, the first thing that fails with a wrong key:
+// the page cannot be decrypted and SQLite reports "file is not a database".
+// It runs traced, so the trace shows that error.
+func verifyKey(conn *sqlite3.SQLiteConn) error {
+	rows, err := conn.Query("SELECT count(*) FROM sqlite_master", nil)
+	if err == nil {
+		err = rows.Next(make([]driver.Value, 1))
+		rows.Close()
+	}
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("%w: %w", errBadKey, err)
+	}
+	return nil
+}
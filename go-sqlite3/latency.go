@@ -0,0 +1,159 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// subBuckets is the number of latencyHistogram buckets per power of two.
+const subBuckets = 16
+
+// latencyHistogram counts timings in log-linear buckets: one per
+// nanosecond below subBuckets, then subBuckets per power of two. Its size
+// depends on the slowest timing only, not on how many were recorded, and
+// a percentile read off it is at most 1/subBuckets too high.
+//
+// The zero value is empty and ready to use. Like rowCounter it has no
+// lock, its owner serializes the calls.
+type latencyHistogram struct {
+	counts []int // by bucketOf, grown up to the slowest bucket seen
+	count  int
+	sum    time.Duration
+	max    time.Duration
+}
+
+// bucketOf returns the bucket of d: d itself while it is small, then
+// the power of two it falls in and which of its subBuckets.
+func bucketOf(d time.Duration) int {
+	v := uint64(d)
+	if v < subBuckets {
+		return int(v)
+	}
+	exp := bits.Len64(v) - 1 // v is in [2^exp, 2^(exp+1))
+	shift := exp - 4         // 2^4 == subBuckets
+	sub := int(v>>shift) - subBuckets
+	return (shift+1)*subBuckets + sub
+}
+
+// bucketMax returns the largest timing that falls in bucket i.
+func bucketMax(i int) time.Duration {
+	if i < subBuckets {
+		return time.Duration(i)
+	}
+	shift := i/subBuckets - 1
+	sub := i % subBuckets
+	return time.Duration((uint64(subBuckets+sub)+1)<<shift - 1)
+}
+
+// bucketMin returns the smallest timing that falls in bucket i.
+func bucketMin(i int) time.Duration {
+	if i == 0 {
+		return 0
+	}
+	return bucketMax(i-1) + 1
+}
+
+func (h *latencyHistogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	i := bucketOf(d)
+	if i >= len(h.counts) {
+		h.counts = append(h.counts, make([]int, i+1-len(h.counts))...)
+	}
+	h.counts[i]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Merge adds the timings of o to h.
+func (h *latencyHistogram) Merge(o *latencyHistogram) {
+	if len(o.counts) > len(h.counts) {
+		h.counts = append(h.counts, make([]int, len(o.counts)-len(h.counts))...)
+	}
+	for i, n := range o.counts {
+		h.counts[i] += n
+	}
+	h.count += o.count
+	h.sum += o.sum
+	if o.max > h.max {
+		h.max = o.max
+	}
+}
+
+// Since returns the timings recorded in h after earlier, a copy h was
+// taken from before. The max stays that of h, there is no telling
+// whether the slowest timing came before or after.
+func (h *latencyHistogram) Since(earlier *latencyHistogram) *latencyHistogram {
+	d := h.clone()
+	for i, n := range earlier.counts {
+		d.counts[i] -= n
+	}
+	d.count -= earlier.count
+	d.sum -= earlier.sum
+	return d
+}
+
+func (h *latencyHistogram) clone() *latencyHistogram {
+	c := *h
+	c.counts = append([]int(nil), h.counts...)
+	return &c
+}
+
+// Count returns how many timings were recorded.
+func (h *latencyHistogram) Count() int {
+	return h.count
+}
+
+// Mean returns the average timing, 0 for none.
+func (h *latencyHistogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// Max returns the slowest timing recorded.
+func (h *latencyHistogram) Max() time.Duration {
+	return h.max
+}
+
+// Percentile returns the nearest-rank p-th percentile (0 < p <= 100),
+// as the upper bound of the bucket it falls in, or 0 when h is empty.
+// It is never more than the slowest timing recorded.
+func (h *latencyHistogram) Percentile(p float64) time.Duration {
+	_, hi := h.PercentileRange(p)
+	return hi
+}
+
+// PercentileRange returns the bounds of the bucket the nearest-rank p-th
+// percentile falls in (0 < p <= 100): the exact percentile is in
+// [lo, hi], and hi is at most 1/subBuckets over lo. The last rank is
+// the slowest timing, which h knows exactly, so lo == hi == Max for it;
+// not after Since, whose Max may be one of the earlier timings. Both
+// are 0 when h is empty.
+func (h *latencyHistogram) PercentileRange(p float64) (lo, hi time.Duration) {
+	if h.count == 0 {
+		return 0, 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	seen := 0
+	for i, n := range h.counts {
+		seen += n
+		if seen < rank {
+			continue
+		}
+		if rank == h.count && bucketOf(h.max) == i {
+			return h.max, h.max
+		}
+		return min(bucketMin(i), h.max), min(bucketMax(i), h.max)
+	}
+	return h.max, h.max
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketBounds(t *testing.T) {
+	for i := 0; i < 60*subBuckets; i++ {
+		max := bucketMax(i)
+		if got := bucketOf(max); got != i {
+			t.Fatalf("bucketOf(bucketMax(%d) = %d) = %d", i, max, got)
+		}
+		if got := bucketOf(max + 1); got != i+1 {
+			t.Fatalf("bucketOf(bucketMax(%d)+1) = %d, want %d", i, got, i+1)
+		}
+		if got := bucketOf(bucketMin(i)); got != i {
+			t.Fatalf("bucketOf(bucketMin(%d)) = %d", i, got)
+		}
+	}
+}
+
+func TestLatencyHistogramPercentile(t *testing.T) {
+	var h latencyHistogram
+	if got := h.Percentile(50); got != 0 {
+		t.Errorf("empty: p50 %s, want 0", got)
+	}
+	// 1ms, 2ms, ... 1000ms: the exact p-th percentile is p*10ms.
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	for _, p := range []float64{1, 50, 90, 95, 99} {
+		exact := time.Duration(p*10) * time.Millisecond
+		got := h.Percentile(p)
+		if got < exact || got > exact+exact/subBuckets {
+			t.Errorf("p%g = %s, want %s up to 1/%d more", p, got, exact, subBuckets)
+		}
+	}
+	if got := h.Percentile(100); got != time.Second {
+		t.Errorf("p100 = %s, want the max, 1s", got)
+	}
+	if got, want := h.Mean(), 500500*time.Microsecond; got != want {
+		t.Errorf("mean %s, want %s", got, want)
+	}
+}
+
+func TestLatencyHistogramBounded(t *testing.T) {
+	var h latencyHistogram
+	for i := 0; i < 100000; i++ {
+		h.Record(time.Duration(i%5000) * time.Microsecond)
+	}
+	size := len(h.counts)
+	for i := 0; i < 100000; i++ {
+		h.Record(time.Duration(i%5000) * time.Microsecond)
+	}
+	if len(h.counts) != size {
+		t.Errorf("%d buckets after as many timings again, had %d", len(h.counts), size)
+	}
+	if size > 30*subBuckets {
+		t.Errorf("%d buckets for timings up to 5ms", size)
+	}
+}
+
+func TestLatencyHistogramSinceMerge(t *testing.T) {
+	var h latencyHistogram
+	for i := 0; i < 10; i++ {
+		h.Record(time.Second)
+	}
+	before := h.clone()
+	for i := 0; i < 10; i++ {
+		h.Record(time.Millisecond)
+	}
+	d := h.Since(before)
+	if d.Count() != 10 || !inBucket(d.Percentile(100), time.Millisecond) || d.Mean() != time.Millisecond {
+		t.Errorf("since: count %d, p100 %s, mean %s; want 10, 1ms, 1ms", d.Count(), d.Percentile(100), d.Mean())
+	}
+
+	var all latencyHistogram
+	all.Merge(d)
+	all.Merge(before)
+	if all.Count() != 20 || all.Max() != time.Second || !inBucket(all.Percentile(50), time.Millisecond) {
+		t.Errorf("merged: count %d, max %s, p50 %s; want 20, 1s, 1ms", all.Count(), all.Max(), all.Percentile(50))
+	}
+}
+
+// inBucket reports whether got is what Percentile makes of want: the top
+// of its bucket.
+func inBucket(got, want time.Duration) bool {
+	return got >= want && got <= want+want/subBuckets
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// LogfmtFormatter renders an event as logfmt key=value pairs, e.g.
+//
+//	t=+0.012s event=profile auto_commit=true conn=0x1f8990d8 stmt=0x1f8a4b28 run_ms=2
+//
+// Empty fields are left out; the SQL texts have gone through the same
+// redaction as in the other formats.
+type LogfmtFormatter struct{}
+
+func (LogfmtFormatter) Format(info sqlite3.TraceInfo) (string, bool) {
+	kv := []interface{}{
+		"t", fmt.Sprintf("+%.3fs", sinceStart().Seconds()),
+		"event", eventName(info.EventCode),
+		"auto_commit", info.AutoCommit,
+		"conn", fmt.Sprintf("0x%x", info.ConnHandle),
+		"stmt", fmt.Sprintf("0x%x", info.StmtHandle),
+	}
+	if info.EventCode == sqlite3.TraceProfile {
+		kv = append(kv, "run_ms", info.RunTimeNanosec/int64(1000000))
+	}
+	if info.StmtOrTrigger != "" {
+		kv = append(kv, "sql", info.StmtOrTrigger)
+	}
+	if info.ExpandedSQL != "" && info.ExpandedSQL != info.StmtOrTrigger {
+		kv = append(kv, "expanded", info.ExpandedSQL)
+	}
+	if isDBError(info.DBError) {
+		kv = append(kv, "err_code", int(info.DBError.ExtendedCode), "err", info.DBError.Error())
+	}
+	return logfmtLine(kv...), false
+}
+
+// logfmtLine joins alternating keys and values into one logfmt line,
+// quoting the values that need it.
+func logfmtLine(kv ...interface{}) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(fmt.Sprint(kv[i]))
+		b.WriteByte('=')
+		b.WriteString(logfmtValue(fmt.Sprint(kv[i+1])))
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// logfmtValue quotes v if it is empty or holds a space, '=', a quote or
+// a control character, with Go's escapes (\" \\ \n ...) inside the quotes.
+func logfmtValue(v string) string {
+	if v == "" || strings.IndexFunc(v, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == '\\' || r == 0x7f
+	}) >= 0 {
+		return strconv.Quote(v)
+	}
+	return v
+}
@@ -3,20 +3,81 @@ package main
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
 	"time"
 
 	sqlite3 "github.com/mattn/go-sqlite3"
 )
 
-func traceCallback(info sqlite3.TraceInfo) int {
-	// Not very readable but may be useful; uncomment next line in case of doubt:
-	//fmt.Printf("Trace: %#v\n", info)
+// SQLite result codes that go-sqlite3 leaves in TraceInfo.DBError
+// after a statement step that went fine.
+const (
+	sqliteRow  = 100 // SQLITE_ROW: another row is available
+	sqliteDone = 101 // SQLITE_DONE: finished executing
+)
+
+// isDBError reports whether e is an actual failure rather than
+// the "no error" codes SQLite returns for a successful step.
+func isDBError(e sqlite3.Error) bool {
+	switch e.Code {
+	case 0, sqliteRow, sqliteDone:
+		return false
+	}
+	return true
+}
+
+// TextFormatter renders an event as a human readable line.
+// It keeps no state, so feeding it hand-made TraceInfo values
+// shows exactly what an event prints.
+type TextFormatter struct{}
+
+func (f TextFormatter) Format(info sqlite3.TraceInfo) (string, bool) {
+	return f.format(newTextEvent(info, false))
+}
+
+// FormatRedacted writes " = <redacted>" in place of the expanded SQL.
+func (f TextFormatter) FormatRedacted(info sqlite3.TraceInfo) (string, bool) {
+	return f.format(newTextEvent(info, true))
+}
+
+func (TextFormatter) format(t textEvent) (string, bool) {
+	info := t.TraceInfo
+	return fmt.Sprintf("Trace: t=+%.3fs ev %s %s conn 0x%x, stmt 0x%x {%q}%s%s%s\n",
+		t.Elapsed, t.Event, t.Mode, info.ConnHandle, info.StmtHandle,
+		info.StmtOrTrigger, t.ExpandedText,
+		t.RunTimeText,
+		t.DBErrorText), false
+}
 
+// textEvent is an event with the pieces of its TextFormatter line worked
+// out, the data a --template is executed with.
+type textEvent struct {
+	sqlite3.TraceInfo
+
+	Elapsed      float64 // seconds since the start, the t=+ of the line
+	Event        string  // eventName of the EventCode
+	Mode         string  // -AC- or +Tx+
+	Redacted     bool    // ExpandedSQL has its literals replaced with '?'
+	ExpandedText string
+	RunTimeText  string
+	DBErrorText  string
+}
+
+func newTextEvent(info sqlite3.TraceInfo, redacted bool) textEvent {
 	var dbErrText string
-	if info.DBError.Code != 0 || info.DBError.ExtendedCode != 0 {
+	if isDBError(info.DBError) {
 		dbErrText = fmt.Sprintf("; DB error: %#v", info.DBError)
 	} else {
 		dbErrText = "."
@@ -39,7 +100,10 @@ func traceCallback(info sqlite3.TraceInfo) int {
 
 	var expandedText string
 	if info.ExpandedSQL != "" {
-		if info.ExpandedSQL == info.StmtOrTrigger {
+		if redacted {
+			// Not " = exp": values were bound, they are just not shown.
+			expandedText = " = <redacted>"
+		} else if info.ExpandedSQL == info.StmtOrTrigger {
 			expandedText = " = exp"
 		} else {
 			expandedText = fmt.Sprintf(" expanded {%q}", info.ExpandedSQL)
@@ -81,94 +145,1413 @@ func traceCallback(info sqlite3.TraceInfo) int {
 		modeText = "+Tx+"
 	}
 
-	fmt.Printf("Trace: ev %d %s conn 0x%x, stmt 0x%x {%q}%s%s%s\n",
-		info.EventCode, modeText, info.ConnHandle, info.StmtHandle,
-		info.StmtOrTrigger, expandedText,
-		runTimeText,
-		dbErrText)
-	return 0
+	return textEvent{
+		TraceInfo:    info,
+		Elapsed:      sinceStart().Seconds(),
+		Event:        eventName(info.EventCode),
+		Mode:         modeText,
+		Redacted:     redacted,
+		ExpandedText: expandedText,
+		RunTimeText:  runTimeText,
+		DBErrorText:  dbErrText,
+	}
+}
+
+// jsonTraceEvent is the one-object-per-line shape written by JSONFormatter.
+// The handles are hex strings: they are pointers and some JSON consumers
+// would lose precision on large integers.
+type jsonTraceEvent struct {
+	ElapsedSec    float64      `json:"elapsed_s"`
+	Event         string       `json:"event"`
+	EventCode     uint32       `json:"event_code"`
+	AutoCommit    bool         `json:"auto_commit"`
+	ConnHandle    string       `json:"conn_handle"`
+	StmtHandle    string       `json:"stmt_handle"`
+	StmtOrTrigger string       `json:"stmt_or_trigger"`
+	Fingerprint   string       `json:"fingerprint,omitempty"`
+	ExpandedSQL   string       `json:"expanded_sql"`
+	RunTimeNanos  int64        `json:"run_time_ns"`
+	DBError       *jsonDBError `json:"db_error"`
+}
+
+type jsonDBError struct {
+	Code         int    `json:"code"`
+	ExtendedCode int    `json:"extended_code"`
+	Message      string `json:"message"`
+}
+
+// JSONFormatter is the machine readable twin of TextFormatter,
+// meant for log aggregation (Loki, ELK, ...) rather than for eyes.
+type JSONFormatter struct{}
+
+func (JSONFormatter) Format(info sqlite3.TraceInfo) (string, bool) {
+	ev := jsonTraceEvent{
+		ElapsedSec:    sinceStart().Seconds(),
+		Event:         eventName(info.EventCode),
+		EventCode:     info.EventCode,
+		AutoCommit:    info.AutoCommit,
+		ConnHandle:    fmt.Sprintf("0x%x", info.ConnHandle),
+		StmtHandle:    fmt.Sprintf("0x%x", info.StmtHandle),
+		StmtOrTrigger: info.StmtOrTrigger,
+		Fingerprint:   fingerprint(info.StmtOrTrigger),
+		ExpandedSQL:   info.ExpandedSQL,
+		RunTimeNanos:  info.RunTimeNanosec,
+	}
+	if isDBError(info.DBError) {
+		ev.DBError = &jsonDBError{
+			Code:         int(info.DBError.Code),
+			ExtendedCode: int(info.DBError.ExtendedCode),
+			Message:      info.DBError.Error(),
+		}
+	}
+
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Sprintf("Trace: failed to marshal event: %s\n", err), false
+	}
+	return string(line) + "\n", false
+}
+
+// processStart is when main started. Trace lines carry their offset from
+// it, read off the monotonic clock, so that two runs line up side by side.
+var processStart time.Time
+
+// fixedClock makes sinceStart always 0, for --deterministic.
+var fixedClock bool
+
+// sinceStart is the offset a trace line carries.
+func sinceStart() time.Duration {
+	if fixedClock {
+		return 0
+	}
+	return time.Since(processStart)
 }
 
 func main() {
-	eventMask := sqlite3.TraceStmt | sqlite3.TraceProfile | sqlite3.TraceRow | sqlite3.TraceClose
-
-	sql.Register("sqlite3_tracing",
-		&sqlite3.SQLiteDriver{
-			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
-				err := conn.SetTrace(&sqlite3.TraceConfig{
-					Callback:        traceCallback,
-					EventMask:       eventMask,
-					WantExpandedSQL: true,
-				})
+	processStart = time.Now()
+	os.Exit(dbMain(os.Args))
+}
+
+// newTracingDriver returns a driver whose connections deliver their trace
+// events to callback. It is not registered with sql.Register, which holds
+// a name for the life of the process: openDB opens it through a
+// driverConnector, so every run, dbMain called again included, has a
+// driver of its own around its own collector and options.
+func newTracingDriver(opts *options, collector *TraceCollector, callback func(sqlite3.TraceInfo) int) driver.Driver {
+	var drv driver.Driver = &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if opts.key != "" {
+				if err := applyKey(conn, opts.key); err != nil {
+					return err
+				}
+			}
+			collector.Connected(conn)
+			if err := registerFuncs(conn); err != nil {
 				return err
-			},
-		})
+			}
+			err := conn.SetTrace(&sqlite3.TraceConfig{
+				Callback:        callback,
+				EventMask:       opts.eventMask,
+				WantExpandedSQL: true,
+			})
+			if err != nil {
+				return err
+			}
+			if opts.busyTimeoutMs >= 0 {
+				if _, err := conn.Exec(fmt.Sprintf("PRAGMA busy_timeout = %d", opts.busyTimeoutMs), nil); err != nil {
+					return err
+				}
+			}
+			if opts.logPragmas {
+				if err := collector.logPragmas(conn); err != nil {
+					return err
+				}
+			}
+			if opts.key != "" {
+				if err := verifyKey(conn); err != nil {
+					return err
+				}
+			}
+			// After SetTrace, so that the ATTACH statements are traced too.
+			return attachAll(conn, opts.attachments)
+		},
+	}
+	if len(opts.attachments) > 0 {
+		drv = &attachDriver{Driver: drv, attachments: opts.attachments}
+	}
+	if opts.trace.trackStmts {
+		drv = &ledgerDriver{Driver: drv, collector: collector}
+	}
+	return drv
+}
 
-	os.Exit(dbMain(os.Args))
+// driverConnector is the driver.Connector of a driver that is not
+// registered: sql.OpenDB takes it in place of a driver name.
+type driverConnector struct {
+	drv driver.Driver
+	dsn string
+}
+
+func (c driverConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.drv.Open(c.dsn)
+}
+
+func (c driverConnector) Driver() driver.Driver {
+	return c.drv
+}
+
+// parseEventMask turns a comma separated list such as "stmt,profile"
+// into a go-sqlite3 trace event mask.
+func parseEventMask(csv string) (uint32, error) {
+	var mask uint32
+next:
+	for _, token := range strings.Split(csv, ",") {
+		token = strings.TrimSpace(token)
+		for _, ev := range traceEvents {
+			if ev.name == token {
+				mask |= ev.code
+				continue next
+			}
+		}
+		return 0, fmt.Errorf("unknown trace event %q", token)
+	}
+	return mask, nil
+}
+
+// traceEvents names the go-sqlite3 trace event codes, for --trace and the output.
+var traceEvents = []struct {
+	name string
+	code uint32
+}{
+	{"stmt", sqlite3.TraceStmt},
+	{"profile", sqlite3.TraceProfile},
+	{"row", sqlite3.TraceRow},
+	{"close", sqlite3.TraceClose},
+}
+
+// eventName returns the short name of an event code, or "unknown(<n>)"
+// for a code that a newer go-sqlite3 might add.
+func eventName(code uint32) string {
+	for _, ev := range traceEvents {
+		if ev.code == code {
+			return ev.name
+		}
+	}
+	return fmt.Sprintf("unknown(%d)", code)
+}
+
+// stringList is a flag.Value collecting every use of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// options holds everything dbMain takes from the command line.
+type options struct {
+	dbPaths   stringList // paths or full DSNs, passed to sql.Open as is
+	dsnParams stringList
+	eventMask uint32
+
+	// key is the SQLCipher passphrase sent to every connection.
+	key string
+
+	// attachments are ATTACHed on every connection.
+	attachments []attachment
+
+	// logPragmas reports the settings of every new connection.
+	logPragmas bool
+
+	// showBuild logs PRAGMA compile_options with the version line.
+	showBuild bool
+
+	// cacheStats samples the page counts around every query, see
+	// cacheSample.
+	cacheStats bool
+
+	// busyTimeoutMs, unless negative, is set as PRAGMA busy_timeout on
+	// every connection.
+	busyTimeoutMs int
+
+	// contend runs the lock contention reproducer, the first connection
+	// holding the lock that long, instead of any query.
+	contend time.Duration
+
+	// database/sql pool settings; every new connection runs the ConnectHook.
+	maxOpen      int
+	maxIdle      int
+	connLifetime time.Duration
+
+	trace traceSettings
+	quiet bool // no tracing at all, for a baseline
+
+	// profileCPU and profileMem are files for the Go CPU and heap profiles.
+	profileCPU string
+	profileMem string
+
+	traceFile     string
+	traceMaxBytes int64
+	traceSocket   string
+	traceDB       string
+	otlpEndpoint  string
+	metricsAddr   string
+
+	// hourly prints the statements by hour of the day at exit.
+	hourly bool
+
+	// color is --color: auto, always or never.
+	color string
+
+	// selfTest runs selfTest instead of anything else.
+	selfTest bool
+
+	// asyncBuffer, when non-zero, is how many trace lines an asyncWriter
+	// buffers on their way out.
+	asyncBuffer int
+
+	// adminAddr serves the live summary, see startAdminServer.
+	adminAddr string
+
+	// traceQueriesOnly stops the trace until the schema is set up, so
+	// that only the query phase is traced.
+	traceQueriesOnly bool
+
+	// tee is a file that gets a copy of the trace lines.
+	tee string
+
+	// syslog sends the trace lines to the local syslog instead of stdout.
+	syslog         bool
+	syslogFacility int
+	syslogTag      string
+
+	// dedup collapses runs of identical trace lines into a count.
+	dedup bool
+
+	// ring, when non-zero, keeps that many trace lines in memory
+	// instead, printed to stderr only if the run fails.
+	ring int
+
+	// reportInterval, when non-zero, prints the statements and rows
+	// traced that often, and the totals at the end.
+	reportInterval time.Duration
+
+	// memReportInterval, when non-zero, prints the database size that often.
+	memReportInterval time.Duration
+
+	// checkpointInterval, in WAL mode, runs a passive checkpoint that often.
+	checkpointInterval time.Duration
+
+	// retries is how often a query failing with SQLITE_BUSY/LOCKED is
+	// run again, waiting retryBaseDelay, then twice as long, and so on.
+	retries        int
+	retryBaseDelay time.Duration
+
+	// timeout bounds the whole run, or each run of --watch; 0 is no limit.
+	timeout time.Duration
+
+	// stmtTimeout, when non-zero, bounds each statement on its own,
+	// inside the overall timeout.
+	stmtTimeout time.Duration
+
+	// explain prints the plan of every query before it runs;
+	// explainOnly prints the plans and runs nothing.
+	explain     bool
+	explainOnly bool
+
+	// requestID, if set, tags the statements of the transaction in the trace.
+	requestID string
+
+	// noTx runs the queries in autocommit mode, outside any transaction.
+	noTx bool
+
+	// readOnly opens the database with mode=ro and writes nothing: no
+	// schema, no transaction. A database that turns out not to be
+	// writable gets the same treatment.
+	readOnly bool
+
+	// txMode is how the transaction begins: deferred, immediate or exclusive.
+	txMode string
+
+	// savepoint runs every query inside a savepoint of the transaction,
+	// released afterwards, or rolled back to first with rollbackSavepoint.
+	savepoint         bool
+	rollbackSavepoint bool
+
+	// init creates the tables and demo rows the built-in query needs.
+	init bool
+
+	// demoWrite runs an insert and a delete to show their results.
+	demoWrite bool
+
+	// bench runs the built-in query that many times and reports throughput.
+	bench int
+
+	// workers, when non-zero, runs the built-in query in a loop on that
+	// many goroutines sharing the pool, for duration, see stressToken.
+	workers  int
+	duration time.Duration
+
+	// users, or the lines of usersFile, are the user names the built-in
+	// query runs for, one after another in the one transaction.
+	users     stringList
+	usersFile string
+
+	// replay is a JSON trace whose statements run instead of any query.
+	replay string
+
+	// script is a file of statements run one by one instead of any query.
+	script string
+
+	// watch is a file with one statement, run again whenever it changes.
+	watch string
+
+	// format is the --format results are written in, table or csv.
+	format string
+
+	// queries are --query with its --arg values, then the positional
+	// arguments; without any, the built-in token query runs.
+	queries []QueryDef
+
+	// failOnError stops at the first query that fails; without it the
+	// rest still run and the run fails at the end.
+	failOnError bool
+
+	// summary is how the summary is written at exit, text or json;
+	// the json one goes to summaryFile if set.
+	summary     string
+	summaryFile string
+
+	// slos are the latency targets checked at exit.
+	slos []sloTarget
+}
+
+// usageErr reports a bad command line the way fs.Parse reports its own
+// errors, on the output of fs, and returns it for parseOptions to return.
+func usageErr(fs *flag.FlagSet, format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	fmt.Fprintln(fs.Output(), err)
+	return err
 }
 
-func dbMain(args []string) int {
-	db, err := sql.Open("sqlite3_tracing", ":memory:")
+func parseOptions(args []string) (*options, error) {
+	opts := &options{}
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.Var(&opts.dbPaths, "db", "database path, :memory:, or a DSN such as 'file:x.db?_journal=WAL' (default ./test.db; repeat it to compare the query results of several databases)")
+	fs.Var(&opts.dsnParams, "dsn-params", "`key=value` DSN parameter appended to --db, e.g. _busy_timeout=5000 (repeatable)")
+	fs.StringVar(&opts.key, "key", "", "passphrase for an encrypted database (needs a go-sqlite3 built with SQLCipher)")
+	var attachValues stringList
+	fs.Var(&attachValues, "attach", "`name=path` of a database to ATTACH to every connection (repeatable)")
+	fs.BoolVar(&opts.showBuild, "show-build", false, "log the compile options of SQLite too, next to its version")
+	fs.BoolVar(&opts.cacheStats, "cache-stats", false, "write the change in page_count and freelist_count, and cache_spill, around every query")
+	fs.BoolVar(&opts.logPragmas, "log-pragmas", false, "write journal_mode, synchronous, foreign_keys, cache_size and busy_timeout of every new connection")
+	fs.IntVar(&opts.busyTimeoutMs, "busy-timeout-ms", -1, "set PRAGMA busy_timeout on every connection (-1 keeps the driver's, 5000 unless _busy_timeout says otherwise)")
+	fs.DurationVar(&opts.contend, "contend", 0, "have one connection hold the write lock this long while a second one waits for it, instead of querying")
+	fs.IntVar(&opts.maxOpen, "max-open", 0, "maximum open connections (0 is unlimited)")
+	fs.IntVar(&opts.maxIdle, "max-idle", 2, "maximum idle connections kept in the pool")
+	fs.DurationVar(&opts.connLifetime, "conn-lifetime", 0, "close connections after this long (0 keeps them)")
+	fs.StringVar(&opts.profileCPU, "profile-cpu", "", "write a Go CPU profile of the run to this file")
+	fs.StringVar(&opts.profileMem, "profile-mem", "", "write a Go heap profile to this file at exit")
+	fs.BoolVar(&opts.quiet, "quiet", false, "use the plain sqlite3 driver: run the same queries without tracing")
+	trace := fs.String("trace", "stmt,profile,row,close", "comma separated trace events: stmt, profile, row, close")
+	fs.StringVar(&opts.traceFile, "trace-file", "", "write traces to this file instead of stdout")
+	fs.BoolVar(&opts.dedup, "dedup", false, "write a run of identical trace lines once, followed by \"... repeated Nx\"")
+	fs.IntVar(&opts.ring, "ring", 0, "keep only the last `N` trace lines, in memory, and print them to stderr if the run fails")
+	fs.StringVar(&opts.tee, "tee", "", "also write the trace lines to this file, next to wherever they go")
+	fs.BoolVar(&opts.syslog, "syslog", false, "send traces to the local syslog instead of stdout, failed statements at LOG_ERR")
+	syslogFacility := fs.String("syslog-facility", "user", "syslog facility of --syslog: user, daemon, local0 ... local7, ...")
+	fs.StringVar(&opts.syslogTag, "syslog-tag", "go-sqlite3-trace", "syslog tag of --syslog")
+	fs.StringVar(&opts.traceSocket, "trace-socket", "", "stream traces to the Unix socket at this path instead of stdout")
+	fs.Int64Var(&opts.traceMaxBytes, "trace-max-bytes", 10<<20, "roll --trace-file over to .1, .2, ... past this size (0 never rolls)")
+	fs.Var(&opts.users, "user", "user `name` to run the built-in query for (default alice; repeatable)")
+	fs.StringVar(&opts.usersFile, "users-file", "", "run the built-in query for each user name in this file, one per line")
+	fs.IntVar(&opts.bench, "bench", 0, "run the built-in query `N` times and print throughput and latency")
+	fs.IntVar(&opts.workers, "workers", 0, "run the built-in query in a loop on `N` goroutines sharing the pool, for --duration, and print throughput and latency")
+	fs.DurationVar(&opts.duration, "duration", 10*time.Second, "how long the --workers run")
+	fs.IntVar(&opts.retries, "retries", 3, "retry the built-in query this many times on SQLITE_BUSY/SQLITE_LOCKED")
+	fs.DurationVar(&opts.retryBaseDelay, "retry-base-delay", 10*time.Millisecond, "wait before the first retry, doubled for each next one")
+	fs.StringVar(&opts.replay, "replay", "", "re-run the statements of a JSON trace file (captured with --no-redact) instead of querying")
+	fs.DurationVar(&opts.timeout, "timeout", time.Minute, "cancel the run, or each run of --watch, after this long (0 is no limit)")
+	fs.DurationVar(&opts.stmtTimeout, "stmt-timeout", 0, "cancel any single statement running longer than this (0 is no limit)")
+	fs.BoolVar(&opts.explain, "explain", false, "print the EXPLAIN QUERY PLAN of each query before running it")
+	fs.BoolVar(&opts.explainOnly, "explain-only", false, "print the query plans like --explain, but run nothing")
+	fs.StringVar(&opts.requestID, "request-id", "", "print req=`ID` on the trace lines of the transaction's statements")
+	fs.BoolVar(&opts.readOnly, "read-only", false, "open the database read-only (mode=ro) and run the queries without creating the schema or a transaction")
+	fs.BoolVar(&opts.noTx, "no-tx", false, "run the queries in autocommit mode instead of one transaction")
+	fs.StringVar(&opts.txMode, "tx-mode", "deferred", "begin the transaction deferred, immediate or exclusive")
+	fs.StringVar(&opts.script, "script", "", "run the semicolon separated statements of this .sql file one by one instead of querying")
+	fs.StringVar(&opts.watch, "watch", "", "run the statement in this .sql file, and again each time the file changes, until interrupted")
+	fs.BoolVar(&opts.init, "init", true, "create the user/token tables and demo rows if missing")
+	fs.StringVar(&opts.traceDB, "trace-db", "", "also store traces in the trace_events table of this SQLite database")
+	fs.DurationVar(&opts.reportInterval, "report-interval", 0, "print the statements and rows traced this often, and the totals at exit (0 never does)")
+	fs.DurationVar(&opts.memReportInterval, "mem-report-interval", 0, "print the size of the database in pages this often (0 never does)")
+	fs.DurationVar(&opts.checkpointInterval, "checkpoint-interval", 0, "in WAL mode, run and report a passive checkpoint this often (0 never does)")
+	fs.BoolVar(&opts.hourly, "hourly", false, "print the statement count and run time by hour of the day at exit")
+	tz := fs.String("tz", "", "time `zone` of the --hourly hours and /hours of --admin-addr, e.g. UTC or Europe/Berlin (default local)")
+	fs.StringVar(&opts.color, "color", "auto", "color the failed statements red and the --slow-ms profiles yellow in the text trace: auto (on a terminal), always or never")
+	fs.BoolVar(&opts.selfTest, "self-test", false, "only check that the tracing driver works, on an in-memory database, and exit 0 if it does")
+	fs.IntVar(&opts.asyncBuffer, "async-buffer", 0, "write the trace lines from a goroutine, buffering this many; lines that find the buffer full are dropped and counted (0 writes them in the trace callback)")
+	fs.StringVar(&opts.adminAddr, "admin-addr", "", "serve the live run summary as JSON on this address, e.g. :8080, at /summary, with /healthz, POST /reset and POST /trace/on and /trace/off")
+	fs.BoolVar(&opts.traceQueriesOnly, "trace-queries-only", false, "trace the query phase only, not the connection and schema setup before it")
+	fs.StringVar(&opts.metricsAddr, "metrics-addr", "", "serve Prometheus metrics on this address, e.g. :9090")
+	fs.StringVar(&opts.otlpEndpoint, "otlp-endpoint", "", "push OpenTelemetry metrics and spans, and the traces of --trace-format otlp-log, to the OTLP/HTTP collector at this `URL`, e.g. http://localhost:4318")
+	fs.StringVar(&opts.format, "format", "table", "output format of query results: table or csv")
+	fs.StringVar(&opts.summary, "summary", "text", "write the summary at exit as text or as one json object")
+	fs.StringVar(&opts.summaryFile, "summary-file", "", "write the --summary json object to this file instead of stdout")
+	fs.BoolVar(&opts.failOnError, "fail-on-error", true, "stop at the first failing query; =false runs the others and fails at the end")
+	query := fs.String("query", "", "run this SQL, with its ? placeholders bound to the --arg values; - reads it from stdin")
+	queryFile := fs.String("query-file", "", "like --query, with the SQL read from this file")
+	queryConfig := fs.String("query-config", "", "JSON `file` of named queries with their args, for --run")
+	run := fs.String("run", "", "run the query of this `name` from --query-config")
+	var argValues stringList
+	fs.Var(&argValues, "arg", "bind value for --query: an integer, null, or else a string (repeatable, in order)")
+	fs.BoolVar(&opts.savepoint, "savepoint", false, "run every query inside SAVEPOINT "+savepointName+", released afterwards")
+	fs.BoolVar(&opts.rollbackSavepoint, "rollback", false, "with --savepoint, undo every query with ROLLBACK TO "+savepointName+" before the RELEASE")
+	fs.BoolVar(&opts.demoWrite, "demo-write", false, "also insert and delete a token row, printing the rows affected and last insert id")
+	demoUDF := fs.Bool("demo-udf", false, "also run "+demoUDFSQL+" to show a Go function called from SQL")
+	noRedact := fs.Bool("no-redact", false, "print bound values in expanded SQL (local debugging only)")
+	fs.Float64Var(&opts.trace.sampleRate, "sample-rate", 1, "write out this share (0..1) of the statements, chosen at random")
+	level := fs.String("log-level", "info", "which trace events to write: info (all), error (failed statements only) or off")
+	// SQLITE_TRACE_FORMAT=json is the older way to ask for JSON.
+	traceFormat := os.Getenv("SQLITE_TRACE_FORMAT")
+	if traceFormat == "" {
+		traceFormat = "text"
+	}
+	fs.StringVar(&opts.trace.format, "trace-format", traceFormat, "format of the trace lines: text, json, logfmt, otlp-log, chrome (a Chrome Trace Event array for chrome://tracing and Perfetto) or ndjson (fixed keys, for warehouse loads)")
+	fs.BoolVar(&opts.trace.checkInvariants, "check-invariants", false, "verify the order of the trace events per statement and fail the run if it is wrong")
+	fs.IntVar(&opts.trace.maxSQLLen, "max-sql-len", 0, "cut the SQL texts of the trace lines to `N` characters, adding sql_len= with the full length (0 never cuts)")
+	fs.Int64Var(&opts.trace.maxEvents, "max-events", 0, "stop tracing after `N` events; the queries go on (0 is no limit)")
+	fs.StringVar(&opts.trace.template, "template", defaultTextTemplate, "Go text/template for the text trace lines, with the TraceInfo fields, .Elapsed, .Event, .Mode, .Redacted, .ExpandedText, .RunTimeText, .DBErrorText and the functions eventName, fingerprint, hex, runMs and isDBError")
+	fs.BoolVar(&opts.trace.trackStmts, "track-stmts", false, "keep a ledger of the statements prepared and closed, and report the ones left open at exit as leaked")
+	fs.BoolVar(&opts.trace.wallTime, "wall-time", false, "follow each profile line with the Go side wall time of the statement, next to SQLite's run time")
+	fs.BoolVar(&opts.trace.rowCounts, "row-counts", false, "follow each profile line with the number of rows the statement scanned")
+	fs.BoolVar(&opts.trace.warnNoExpand, "warn-no-expand", false, "note the statements with bind parameters that came without their expanded SQL")
+	fs.BoolVar(&opts.trace.ioAccounting, "io-accounting", false, "open the database through a VFS counting the bytes read and written, and add them to the profile lines; needs a build with -tags io_accounting")
+	fs.BoolVar(&opts.trace.deterministic, "deterministic", false, "number the handles 1, 2, ... and zero the timings, so that the same run gives the same trace")
+	fs.BoolVar(&opts.trace.goid, "debug-goid", false, "add g=<n>, the goroutine running the trace callback, to each line (slow, for debugging)")
+	fs.BoolVar(&opts.trace.verbose, "verbose-trace", false, "also dump every event as a Go struct (%#v), for debugging the driver")
+	var matchExpr, excludeExpr string
+	fs.StringVar(&matchExpr, "match", "", "trace only the statements whose SQL matches this `regexp`, e.g. '\\btoken\\b'")
+	fs.StringVar(&excludeExpr, "exclude", "", "do not trace the statements whose SQL matches this `regexp`")
+	var sloValues stringList
+	fs.Var(&sloValues, "slo", "fail the run if a latency percentile exceeds a target, e.g. p99=50ms; p50, p95, p99 or max (repeatable)")
+	maxStmtMs := fs.Int("max-stmt-ms", 0, "abort the run with exit code 8 as soon as a statement runs longer than this many milliseconds (0 never does)")
+	slowMs := fs.Int("slow-ms", 0, "only print profile events at least this many milliseconds long (0 prints everything)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return nil, err
+	}
+
+	// Errors from here on are reported the same way fs.Parse reports its own.
+	var err error
+	if opts.eventMask, err = parseEventMask(*trace); err != nil {
+		return nil, usageErr(fs, "%w", err)
+	}
+	if opts.trace.level, err = parseLogLevel(*level); err != nil {
+		return nil, usageErr(fs, "%w", err)
+	}
+	for _, v := range attachValues {
+		a, err := parseAttachment(v)
+		if err != nil {
+			return nil, usageErr(fs, "%w", err)
+		}
+		opts.attachments = append(opts.attachments, a)
+	}
+	for _, e := range []struct {
+		flag, expr string
+		re         **regexp.Regexp
+	}{
+		{"match", matchExpr, &opts.trace.match},
+		{"exclude", excludeExpr, &opts.trace.exclude},
+	} {
+		if e.expr == "" {
+			continue
+		}
+		re, err := regexp.Compile(e.expr)
+		if err != nil {
+			return nil, usageErr(fs, "--%s: %w", e.flag, err)
+		}
+		*e.re = re
+	}
+	for _, v := range sloValues {
+		t, err := parseSLO(v)
+		if err != nil {
+			return nil, usageErr(fs, "%w", err)
+		}
+		opts.slos = append(opts.slos, t)
+	}
+	if *tz != "" {
+		loc, err := time.LoadLocation(*tz)
+		if err != nil {
+			return nil, usageErr(fs, "--tz: %w", err)
+		}
+		opts.trace.tz = loc
+	}
+	if opts.hourly && opts.quiet {
+		return nil, usageErr(fs, "--hourly counts the traced statements, drop --quiet")
+	}
+	switch opts.color {
+	case "auto", "always", "never":
+	default:
+		return nil, usageErr(fs, "--color %q: want auto, always or never", opts.color)
+	}
+	if opts.asyncBuffer < 0 {
+		return nil, usageErr(fs, "--async-buffer %d: want 0 or a positive number", opts.asyncBuffer)
+	}
+	if opts.adminAddr != "" && opts.quiet {
+		return nil, usageErr(fs, "--admin-addr serves the traced aggregates, drop --quiet")
+	}
+	if opts.traceQueriesOnly && (opts.quiet || opts.replay != "" || opts.script != "" || opts.contend > 0) {
+		return nil, usageErr(fs, "--trace-queries-only starts the trace after the schema setup, which --quiet, --replay, --script and --contend do without")
+	}
+	if len(opts.slos) > 0 && opts.quiet {
+		return nil, usageErr(fs, "--slo checks the traced latencies, drop --quiet")
+	}
+	if opts.key != "" && opts.quiet {
+		return nil, usageErr(fs, "--key is sent by the tracing driver's ConnectHook, drop --quiet")
+	}
+	if opts.busyTimeoutMs >= 0 && opts.quiet {
+		return nil, usageErr(fs, "--busy-timeout-ms is set by the tracing driver's ConnectHook, drop --quiet")
+	}
+	if opts.contend > 0 && opts.maxOpen == 1 {
+		return nil, usageErr(fs, "--contend needs two connections, raise --max-open")
+	}
+	if opts.trace.ioAccounting && opts.quiet {
+		return nil, usageErr(fs, "--io-accounting writes to the trace, drop --quiet")
+	}
+	if opts.trace.ioAccounting && !ioAccountingBuilt {
+		return nil, usageErr(fs, "--io-accounting needs a build with -tags io_accounting")
+	}
+	if opts.trace.ioAccounting {
+		// Registered by dbMain, parsing has no side effects.
+		opts.dsnParams = append(opts.dsnParams, "vfs="+countingVFSName)
+	}
+	if opts.trace.trackStmts && opts.quiet {
+		return nil, usageErr(fs, "--track-stmts wraps the tracing driver, drop --quiet")
+	}
+	if opts.cacheStats && opts.quiet {
+		return nil, usageErr(fs, "--cache-stats writes to the trace, drop --quiet")
+	}
+	if opts.logPragmas && opts.quiet {
+		return nil, usageErr(fs, "--log-pragmas runs in the tracing driver's ConnectHook, drop --quiet")
+	}
+	if len(opts.attachments) > 0 && opts.quiet {
+		return nil, usageErr(fs, "--attach runs in the tracing driver's ConnectHook, drop --quiet")
+	}
+	switch opts.txMode {
+	case "deferred", "immediate", "exclusive":
+	default:
+		return nil, usageErr(fs, "unknown --tx-mode %q, want deferred, immediate or exclusive", opts.txMode)
+	}
+	if opts.readOnly && (opts.txMode != "deferred" || opts.requestID != "" || opts.demoWrite) {
+		return nil, usageErr(fs, "--read-only runs without a transaction or writes: it takes neither --tx-mode, --request-id nor --demo-write")
+	}
+	if opts.readOnly {
+		opts.noTx = true
+		opts.dsnParams = append(opts.dsnParams, "mode=ro")
+	}
+	if opts.noTx && (opts.txMode != "deferred" || opts.requestID != "") {
+		return nil, usageErr(fs, "--no-tx runs without a transaction: it takes neither --tx-mode nor --request-id")
+	}
+	if opts.savepoint && opts.noTx {
+		return nil, usageErr(fs, "--savepoint nests in the transaction, it takes neither --no-tx nor --read-only")
+	}
+	if opts.rollbackSavepoint && !opts.savepoint {
+		return nil, usageErr(fs, "--rollback rolls back to the savepoint, it needs --savepoint")
+	}
+	if _, err := newTraceFormatter(opts.trace.format); err != nil {
+		return nil, usageErr(fs, "%w", err)
+	}
+	if opts.trace.template != defaultTextTemplate {
+		if opts.trace.format != "text" {
+			return nil, usageErr(fs, "--template replaces the text format, it takes no --trace-format %s", opts.trace.format)
+		}
+		if _, err := newTemplateFormatter(opts.trace.template); err != nil {
+			return nil, usageErr(fs, "%w", err)
+		}
+	}
+	if opts.summary != "text" && opts.summary != "json" {
+		return nil, usageErr(fs, "unknown --summary %q, want text or json", opts.summary)
+	}
+	if opts.summaryFile != "" && opts.summary != "json" {
+		return nil, usageErr(fs, "--summary-file takes the --summary json object")
+	}
+	if _, err := newRowWriter(opts.format, io.Discard); err != nil {
+		return nil, usageErr(fs, "%w", err)
+	}
+	if *query != "" && *queryFile != "" {
+		return nil, usageErr(fs, "--query and --query-file are mutually exclusive")
+	}
+	if *query == "-" {
+		*queryFile = "-"
+	}
+	if *queryFile != "" {
+		if *query, err = readQuery(*queryFile); err != nil {
+			return nil, usageErr(fs, "%w", err)
+		}
+	}
+	if *query != "" {
+		if n := countPlaceholders(*query); n != len(argValues) {
+			return nil, usageErr(fs, "--query has %d placeholders but %d --arg values were given", n, len(argValues))
+		}
+		q := QueryDef{SQL: *query}
+		for _, a := range argValues {
+			q.Args = append(q.Args, parseArg(a))
+		}
+		opts.queries = append(opts.queries, q)
+	} else if len(argValues) > 0 {
+		return nil, usageErr(fs, "--arg binds values of --query, which is missing")
+	}
+	if (*run == "") != (*queryConfig == "") {
+		return nil, usageErr(fs, "--run and --query-config go together")
+	}
+	if *run != "" {
+		defs, err := loadQueryConfig(*queryConfig)
+		if err == nil {
+			var q QueryDef
+			if q, err = lookupQuery(defs, *run); err == nil {
+				opts.queries = append(opts.queries, q)
+			}
+		}
+		if err != nil {
+			return nil, usageErr(fs, "%w", err)
+		}
+	}
+	for _, q := range fs.Args() {
+		opts.queries = append(opts.queries, QueryDef{SQL: q})
+	}
+	if *demoUDF {
+		if opts.quiet {
+			return nil, usageErr(fs, "--demo-udf needs the functions the tracing driver registers, drop --quiet")
+		}
+		opts.queries = append(opts.queries, QueryDef{SQL: demoUDFSQL})
+	}
+	if opts.watch != "" && (len(opts.queries) > 0 || opts.bench > 0 || opts.replay != "" || opts.script != "" || opts.explain) {
+		return nil, usageErr(fs, "--watch runs the statement of its file only: no queries, --bench, --replay, --script or --explain")
+	}
+	if (len(opts.users) > 0 || opts.usersFile != "") && (len(opts.queries) > 0 || opts.bench > 0) {
+		return nil, usageErr(fs, "--user and --users-file are for the built-in query: no queries or --bench")
+	}
+	if len(opts.users) > 0 && opts.usersFile != "" {
+		return nil, usageErr(fs, "--user and --users-file are mutually exclusive")
+	}
+	if opts.bench > 0 && len(opts.queries) > 0 {
+		return nil, usageErr(fs, "--bench runs the built-in query, it does not take queries")
+	}
+	if opts.workers < 0 || opts.duration <= 0 {
+		return nil, usageErr(fs, "--workers must not be negative and --duration must be positive")
+	}
+	if opts.workers > 0 && (len(opts.queries) > 0 || opts.bench > 0) {
+		return nil, usageErr(fs, "--workers runs the built-in query, it takes no queries or --bench")
+	}
+	opts.explain = opts.explain || opts.explainOnly
+	if len(opts.dbPaths) == 0 {
+		opts.dbPaths = stringList{"./test.db"}
+	}
+	if len(opts.dbPaths) > 1 && (len(opts.queries) == 0 || opts.bench > 0 || opts.replay != "" || opts.script != "" || opts.explain) {
+		return nil, usageErr(fs, "several --db values compare the results of the queries: give queries, and no --bench, --replay, --script or --explain")
+	}
+	if opts.traceFile != "" && opts.traceSocket != "" {
+		return nil, usageErr(fs, "--trace-file and --trace-socket are mutually exclusive")
+	}
+	if opts.syslog && (opts.traceFile != "" || opts.traceSocket != "" || opts.ring > 0 || opts.dedup) {
+		return nil, usageErr(fs, "--syslog replaces --trace-file, --trace-socket, --ring and --dedup")
+	}
+	if opts.trace.verbose && opts.trace.format == "ndjson" {
+		return nil, usageErr(fs, "--verbose-trace adds lines of its own, which --trace-format ndjson has no room for")
+	}
+	if opts.dedup && opts.trace.format == "ndjson" {
+		return nil, usageErr(fs, "--dedup adds lines of its own, which --trace-format ndjson has no room for")
+	}
+	if opts.trace.format == "otlp-log" && opts.otlpEndpoint != "" && (opts.traceFile != "" || opts.traceSocket != "" || opts.ring > 0 || opts.syslog) {
+		return nil, usageErr(fs, "--otlp-endpoint ships the otlp-log traces, which replaces --trace-file, --trace-socket, --ring and --syslog")
+	}
+	if opts.syslogFacility, err = parseSyslogFacility(*syslogFacility); err != nil {
+		return nil, usageErr(fs, "%w", err)
+	}
+	if opts.ring < 0 || (opts.ring > 0 && (opts.traceFile != "" || opts.traceSocket != "")) {
+		return nil, usageErr(fs, "--ring takes a positive number of lines and replaces --trace-file and --trace-socket")
+	}
+	if opts.trace.maxSQLLen < 0 {
+		return nil, usageErr(fs, "--max-sql-len %d: want 0 or a positive number", opts.trace.maxSQLLen)
+	}
+	if opts.trace.maxEvents < 0 {
+		return nil, usageErr(fs, "--max-events %d: want 0 or a positive number", opts.trace.maxEvents)
+	}
+	if opts.trace.sampleRate < 0 || opts.trace.sampleRate > 1 {
+		return nil, usageErr(fs, "--sample-rate %g: want a value from 0 to 1", opts.trace.sampleRate)
+	}
+	opts.trace.sampleSeed = time.Now().UnixNano()
+	if opts.trace.deterministic {
+		// Sampling at random would defeat the purpose.
+		opts.trace.sampleSeed = 1
+	}
+	// Set either way, so that a run without --deterministic after one
+	// with it, in the same process, has its clock back.
+	fixedClock = opts.trace.deterministic
+	opts.trace.slowThreshold = time.Duration(*slowMs) * time.Millisecond
+	if *maxStmtMs < 0 {
+		return nil, usageErr(fs, "--max-stmt-ms %d: want 0 or a positive number", *maxStmtMs)
+	}
+	if *maxStmtMs > 0 && opts.quiet {
+		return nil, usageErr(fs, "--max-stmt-ms watches the traced run times, drop --quiet")
+	}
+	opts.trace.maxStmt = time.Duration(*maxStmtMs) * time.Millisecond
+	opts.trace.redact = !*noRedact
+	return opts, nil
+}
+
+// traceSinkBatch is how many events --trace-db inserts per transaction.
+const traceSinkBatch = 100
+
+// Exit codes returned by dbMain.
+const (
+	exitOK      = 0
+	exitFailure = 1 // the database or a query failed
+	exitUsage   = 2 // bad command line
+	exitNoRows  = 3 // the built-in query found no token for the user
+	exitBadKey  = 4 // --key does not open the database
+	exitOrder   = 5 // --check-invariants saw events out of order
+
+	exitMismatch = 6 // the --db databases returned different results
+	exitSLO      = 7 // a --slo target was missed
+	exitCeiling  = 8 // a statement ran longer than --max-stmt-ms
+
+	// A statement failed, by the category of classifyError.
+	exitConstraint = 10
+	exitBusy       = 11
+	exitIO         = 12
+	exitSyntax     = 13
+	exitSQL        = 14
+)
+
+func dbMain(args []string) (code int) {
+	opts, err := parseOptions(args)
 	if err != nil {
-		fmt.Printf("Failed to open database: %#+v\n", err)
-		return 1
+		return exitUsage
+	}
+	if opts.selfTest {
+		return selfTest(opts)
+	}
+	if opts.trace.ioAccounting {
+		if err := registerCountingVFS(); err != nil {
+			log.Printf("io accounting got error: %s\n", err)
+			return exitFailure
+		}
+	}
+
+	if opts.profileCPU != "" {
+		stop, err := startCPUProfile(opts.profileCPU)
+		if err != nil {
+			log.Printf("start CPU profile got error: %s\n", err)
+			return exitFailure
+		}
+		// Deferred first so that it runs last: a panic also stops it.
+		defer stop()
+	}
+	if opts.profileMem != "" {
+		defer func() {
+			if err := writeHeapProfile(opts.profileMem); err != nil {
+				log.Printf("write heap profile got error: %s\n", err)
+			}
+		}()
+	}
+
+	opts.trace.out = os.Stdout
+	if opts.ring > 0 {
+		ring := newRingBuffer(opts.ring)
+		// Deferred first so that it runs last, with the close events in.
+		defer func() {
+			if code != exitOK {
+				ring.Dump(os.Stderr)
+			}
+		}()
+		opts.trace.out = ring
+	}
+	if opts.traceFile != "" {
+		w, err := newRotatingWriter(opts.traceFile, opts.traceMaxBytes)
+		if err != nil {
+			fmt.Printf("Failed to open trace file: %s\n", err)
+			return exitFailure
+		}
+		// Closed after the database, so the close events are written too.
+		defer w.Close()
+		opts.trace.out = w
+	}
+	var socket *socketWriter
+	if opts.traceSocket != "" {
+		socket = newSocketWriter(opts.traceSocket)
+		// Closed after the database, so the close events are sent too.
+		defer socket.Close()
+		opts.trace.out = socket
+	}
+	if opts.syslog {
+		w, err := newSyslogWriter(opts.syslogFacility, opts.syslogTag)
+		if err != nil {
+			log.Printf("syslog unavailable, writing traces to stderr: %s\n", err)
+			opts.trace.out = os.Stderr
+		} else {
+			defer w.Close()
+			opts.trace.out = w
+		}
+	}
+	if opts.trace.format == "otlp-log" && opts.otlpEndpoint != "" {
+		w := newOTLPLogWriter(opts.otlpEndpoint)
+		// Closed after the database, so the close events are sent too.
+		defer w.Close()
+		opts.trace.out = w
+	}
+	if opts.tee != "" {
+		t, err := newTeeFile(opts.tee)
+		if err != nil {
+			fmt.Printf("Failed to open tee file: %s\n", err)
+			return exitFailure
+		}
+		// Closed after the database, so the close events are written too.
+		defer func() {
+			if err := t.Close(); err != nil {
+				log.Printf("tee file got error: %s\n", err)
+			}
+		}()
+		// Each line is a single Write, which MultiWriter hands to the
+		// writers in turn, so the lines stay whole on both sides.
+		opts.trace.out = io.MultiWriter(opts.trace.out, t)
+	}
+	// Decided on the destination: the writers below pass lines through.
+	opts.trace.color = opts.trace.format == "text" && useColor(opts.color, opts.trace.out)
+	if opts.trace.format == "chrome" {
+		c := newChromeWriter(opts.trace.out)
+		// Closed after the database, and on every return, so that the
+		// array is always ended.
+		defer c.Close()
+		opts.trace.out = c
+	}
+	if opts.dedup {
+		d := newDedupWriter(opts.trace.out)
+		// Closed after the database, so that a repeat of the last
+		// lines is still counted.
+		defer d.Close()
+		opts.trace.out = d
+	}
+	if opts.asyncBuffer > 0 {
+		a := newAsyncWriter(opts.trace.out, opts.asyncBuffer)
+		// Closed after the database, and before the writers it feeds.
+		defer a.Close()
+		opts.trace.out = a
+	}
+	if opts.traceDB != "" {
+		sink, err := newTraceSink(opts.traceDB, traceSinkBatch)
+		if err != nil {
+			fmt.Printf("Failed to open trace database: %s\n", err)
+			return exitFailure
+		}
+		defer func() {
+			if err := sink.Close(); err != nil {
+				log.Printf("trace database got error: %s\n", err)
+			}
+		}()
+		opts.trace.sink = sink
+	}
+
+	if opts.metricsAddr != "" {
+		opts.trace.metrics = newPromMetrics()
+		if socket != nil {
+			opts.trace.metrics.CountDropped(socket.Dropped)
+		}
+	}
+
+	if opts.otlpEndpoint != "" {
+		meter, shutdown, err := startOTLPMeter(context.Background(), opts.otlpEndpoint)
+		if err != nil {
+			log.Printf("start OTLP metrics got error: %s\n", err)
+			return exitFailure
+		}
+		// Deferred before the database is closed so that it runs after,
+		// with the measurements of the close events in.
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdown(ctx); err != nil {
+				log.Printf("push OTLP metrics got error: %s\n", err)
+			}
+		}()
+		opts.trace.meter = meter
+
+		shutdownTracer, err := startOTLPTracer(context.Background(), opts.otlpEndpoint)
+		if err != nil {
+			log.Printf("start OTLP traces got error: %s\n", err)
+			return exitFailure
+		}
+		// Like the meter's, after the database is closed: the close
+		// events end the spans still open.
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdownTracer(ctx); err != nil {
+				log.Printf("push OTLP spans got error: %s\n", err)
+			}
+		}()
+	}
+
+	// The collector still exists with --quiet, it just never sees an event.
+	collector := newTraceCollector(opts.trace)
+	if opts.traceQueriesOnly {
+		// Started again once the schema is there.
+		collector.StopTrace()
+	}
+	if !opts.quiet {
+		// Deferred before the database is closed so that it runs after,
+		// with the close events checked too.
+		defer func() {
+			if n := collector.InvariantViolations(); n > 0 && code == exitOK {
+				log.Printf("%d trace invariant violations\n", n)
+				code = exitOrder
+			}
+		}()
+		if len(opts.slos) > 0 {
+			defer func() {
+				if !checkSLOs(os.Stdout, collector.profiles.Latencies(), opts.slos) && code == exitOK {
+					code = exitSLO
+				}
+			}()
+		}
+		// Deferred first so that they run last, after the database is closed.
+		if opts.summary == "json" {
+			defer func() {
+				if err := writeRunSummary(opts.summaryFile, collector); err != nil {
+					log.Printf("write summary got error: %s\n", err)
+				}
+			}()
+		} else {
+			// Deferred first so that it comes last.
+			if opts.hourly {
+				defer collector.HourlyReport(os.Stdout)
+			}
+			defer collector.Summary(os.Stdout)
+			defer collector.profiles.Report(os.Stdout)
+		}
+	}
+
+	if len(opts.dbPaths) > 1 {
+		return compareMain(opts, collector)
+	}
+
+	var drv driver.Driver = &sqlite3.SQLiteDriver{} // the plain one, no ConnectHook
+	if !opts.quiet {
+		drv = newTracingDriver(opts, collector, collector.Callback)
+	}
+	watch := newConnWatch(drv)
+	db, dsn, code := openDB(watch, opts.dbPaths[0], opts)
+	if code != exitOK {
+		return code
 	}
 	defer db.Close()
+	// The DSN parameters map to PRAGMAs; show what actually took effect.
+	var journalMode string
+	if err := db.QueryRowContext(context.Background(), "PRAGMA journal_mode").Scan(&journalMode); err != nil {
+		log.Printf("PRAGMA journal_mode got error: %s\n", err)
+		return exitFailure
+	}
+	log.Printf("connected to %s, journal_mode=%s\n", dsn, journalMode)
+	if err := logBuild(context.Background(), db, opts.showBuild); err != nil {
+		log.Printf("read sqlite build got error: %s\n", err)
+		return exitFailure
+	}
+
+	// A watch runs until interrupted, with the --timeout for each of its runs.
+	timeout := opts.timeout
+	if opts.watch != "" {
+		timeout = 0
+	}
+	timeoutCtx, cancel := withTimeout(context.Background(), timeout)
+	defer cancel()
+	// Ctrl-C cancels the running query; the deferred Rollback cleans up.
+	ctx, stop := signal.NotifyContext(timeoutCtx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
+	if opts.trace.maxStmt > 0 {
+		var trip context.CancelCauseFunc
+		ctx, trip = context.WithCancelCause(ctx)
+		collector.OnCeiling(func(sql string, run time.Duration) {
+			log.Printf("statement ran %s, over --max-stmt-ms %s, aborting: %q\n", run, opts.trace.maxStmt, sql)
+			trip(errCeiling)
+		})
+		// Whatever the statements cancelled by it returned.
+		defer func() {
+			if collector.CeilingExceeded() {
+				code = exitCeiling
+			}
+		}()
+	}
+
+	if opts.trace.metrics != nil {
+		if err := startMetricsServer(ctx, opts.metricsAddr, opts.trace.metrics); err != nil {
+			log.Printf("start metrics server got error: %s\n", err)
+			return exitFailure
+		}
+	}
+	if opts.adminAddr != "" {
+		if err := startAdminServer(ctx, opts.adminAddr, collector); err != nil {
+			log.Printf("start admin server got error: %s\n", err)
+			return exitFailure
+		}
+	}
+
+	stopReports := startThroughputReports(ctx, opts.reportInterval, collector)
+	defer stopReports()
+	stopMemReports := startMemReports(ctx, db, opts.memReportInterval, collector)
+	defer stopMemReports()
+	stopCheckpoints := startCheckpoints(ctx, db, journalMode, opts.checkpointInterval, collector)
+	defer stopCheckpoints()
+
+	if opts.replay != "" {
+		f, err := os.Open(opts.replay)
+		if err != nil {
+			fmt.Printf("Failed to open replay file: %s\n", err)
+			return exitFailure
+		}
+		defer f.Close()
+		if err := replay(ctx, db, f); err != nil {
+			log.Printf("replay got error: %s\n", err)
+			return exitFailure
+		}
+		return exitOK
+	}
+
+	if opts.contend > 0 {
+		if err := contend(ctx, db, opts.contend); err != nil {
+			logCancellation(ctx, timeoutCtx)
+			return exitCodeFor(err)
+		}
+		return exitOK
+	}
+
+	if opts.script != "" {
+		script, err := os.ReadFile(opts.script)
+		if err != nil {
+			fmt.Printf("Failed to read script: %s\n", err)
+			return exitFailure
+		}
+		if err := runScript(ctx, db, string(script)); err != nil {
+			log.Printf("script got error: %s\n", err)
+			logCancellation(ctx, timeoutCtx)
+			return exitFailure
+		}
+		return exitOK
+	}
+
+	if opts.init && !opts.readOnly {
+		err := ensureSchema(ctx, db)
+		switch {
+		case isReadOnly(err):
+			// The reads work all the same; only a commit would fail.
+			log.Printf("database is not writable, running read-only without a transaction: %s\n", err)
+			opts.readOnly, opts.noTx = true, true
+		case err != nil:
+			log.Printf("ensure schema got error: %s\n", err)
+			return exitFailure
+		}
+	}
+	if opts.traceQueriesOnly {
+		collector.StartTrace()
+	}
+
+	if opts.workers > 0 {
+		if err := stressToken(ctx, db, "alice", opts.workers, opts.duration, opts.stmtTimeout); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return exitNoRows
+			}
+			logCancellation(ctx, timeoutCtx)
+			return exitCodeFor(err)
+		}
+		return exitOK
+	}
+
+	if opts.watch != "" {
+		if err := watchQuery(ctx, db, opts.watch, opts); err != nil {
+			log.Printf("watch got error: %s\n", err)
+			return exitFailure
+		}
+		return exitOK
+	}
+
+	// Without a transaction the statements go to the pool and run in
+	// autocommit mode, -AC- in the trace.
+	var (
+		tx     txn
+		runner querier = db
+	)
+	if !opts.noTx {
+		tx, err = beginTx(ctx, db, opts.txMode)
+		if err != nil {
+			log.Printf("begin transaction got error: %s\n", err)
+			return exitFailure
+		}
+		// A closure: reconnect may replace tx.
+		defer func() {
+			if tx != nil {
+				tx.Rollback()
+			}
+		}()
+		runner = tx
+	}
+	// Deferred after the Rollback so that it runs before it, and before
+	// the database is closed.
+	stmts := newStmtCache()
+	// With --summary json stdout is for the summary; the free-form
+	// reports go to stderr then.
+	var reports io.Writer = os.Stdout
+	if opts.summary == "json" {
+		reports = os.Stderr
+	}
+	defer func() {
+		if err := stmts.Close(); err != nil {
+			log.Printf("close statements got error: %s\n", err)
+		}
+		stmts.Report(reports)
+		// What the cache did not close, database/sql only closes with
+		// the transaction or the connection.
+		collector.LeakedStmts(reports)
+	}()
+	if opts.requestID != "" {
+		if err := tagRequest(WithRequestID(ctx, opts.requestID), runner); err != nil {
+			log.Printf("tag request got error: %s\n", err)
+			return exitFailure
+		}
+	}
+
+	if opts.explain {
+		plans := opts.queries
+		if len(plans) == 0 {
+			plans = []QueryDef{{SQL: tokenSQL, Args: []interface{}{"alice"}}}
+		}
+		for _, q := range plans {
+			if err := explainQuery(ctx, runner, os.Stdout, q.SQL, q.Args...); err != nil {
+				log.Printf("explain %q got error: %s\n", q.SQL, err)
+				return exitFailure
+			}
+		}
+		if opts.explainOnly {
+			return exitOK
+		}
+	}
+
+	if opts.demoWrite {
+		if err := demoWrite(ctx, runner, collector); err != nil {
+			log.Printf("demo write got error: %s\n", err)
+			return exitCodeFor(err)
+		}
+	}
+
+	var cacheStats *TraceCollector // nil leaves withCacheStats out
+	if opts.cacheStats {
+		cacheStats = collector
+	}
+	var savepoint string // empty leaves withSavepoint out
+	if opts.savepoint {
+		savepoint = savepointName
+	}
+
+	if opts.bench > 0 {
+		if err := benchToken(ctx, runner, stmts, "alice", opts.bench, opts.stmtTimeout, collector.profiles); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return exitNoRows
+			}
+			logCancellation(ctx, timeoutCtx)
+			return exitCodeFor(err)
+		}
+	} else if len(opts.queries) == 0 {
+		var users ParamProvider
+		if opts.usersFile != "" {
+			f, err := newFileProvider(opts.usersFile)
+			if err != nil {
+				fmt.Printf("Failed to open users file: %s\n", err)
+				return exitFailure
+			}
+			defer f.Close()
+			users = f
+		} else {
+			names := opts.users
+			if len(names) == 0 {
+				names = stringList{"alice"}
+			}
+			sets := make([][]interface{}, len(names))
+			for i, name := range names {
+				sets[i] = []interface{}{name}
+			}
+			users = newSliceProvider(sets...)
+		}
+		for {
+			args, ok := users.Next()
+			if !ok {
+				break
+			}
+			var err error
+			runner, tx, err = reconnectOnce(ctx, db, watch, runner, tx, opts, tokenSQL, func(runner querier) error {
+				return withRetry(ctx, opts.retries+1, opts.retryBaseDelay, func() error {
+					qctx, cancel := withStmtTimeout(ctx, opts.stmtTimeout)
+					defer cancel()
+					return withCacheStats(qctx, runner, cacheStats, tokenSQL, func() error {
+						return withSavepoint(qctx, runner, savepoint, opts.rollbackSavepoint, func() error {
+							return queryToken(qctx, runner, stmts, args...)
+						})
+					})
+				})
+			})
+			if errors.Is(err, sql.ErrNoRows) {
+				return exitNoRows
+			}
+			if err != nil {
+				logCancellation(ctx, timeoutCtx)
+				return exitCodeFor(err)
+			}
+		}
+		if f, ok := users.(*FileProvider); ok && f.Err() != nil {
+			log.Printf("read users file got error: %s\n", f.Err())
+			return exitFailure
+		}
+	}
+	out, _ := newRowWriter(opts.format, os.Stdout) // validated by parseOptions
+	tx, firstErr, err := runQueries(ctx, db, watch, tx, opts, func(ctx context.Context, runner querier, q QueryDef) error {
+		qctx, cancel := withStmtTimeout(ctx, opts.stmtTimeout)
+		defer cancel()
+		return withCacheStats(qctx, runner, cacheStats, q.SQL, func() error {
+			return withSavepoint(qctx, runner, savepoint, opts.rollbackSavepoint, func() error {
+				return runQuery(qctx, runner, out, q.SQL, q.Args...)
+			})
+		})
+	})
+	if err != nil {
+		logCancellation(ctx, timeoutCtx)
+		return exitCodeFor(err)
+	}
+	if tx != nil {
+		if err := tx.Commit(); err != nil {
+			log.Printf("commit got error: %s\n", err)
+			return exitFailure
+		}
+	}
+	fmt.Println("--------- complete --------")
+
+	// SQLite undoes just the failed statement, so the others were
+	// committed; the run still fails, by the first error.
+	if firstErr != nil {
+		return exitCodeFor(firstErr)
+	}
+	return exitOK
+}
+
+// openDB opens the database at path with the driver, the DSN parameters
+// and the pool settings of opts, and connects to it. On failure it
+// returns the exit code to end the run with.
+func openDB(drv driver.Driver, path string, opts *options) (db *sql.DB, dsn string, code int) {
+	if opts.readOnly {
+		path = readOnlyDSN(path)
+	}
+	dsn, err := appendDSNParams(path, opts.dsnParams)
+	if err != nil {
+		fmt.Println(err)
+		return nil, "", exitUsage
+	}
+	db = sql.OpenDB(driverConnector{drv: drv, dsn: dsn})
+	db.SetMaxOpenConns(opts.maxOpen)
+	db.SetMaxIdleConns(opts.maxIdle)
+	db.SetConnMaxLifetime(opts.connLifetime)
+
+	// sql.Open does not connect; a bad path only shows up here.
+	start := time.Now()
 	err = db.Ping()
 	if err != nil {
-		log.Panic(err)
+		log.Printf("connect to %s got error: %s\n", dsn, err)
+		db.Close()
+		// go-sqlite3 reads the file itself before the ConnectHook runs,
+		// so a wrong key can also fail there, before verifyKey.
+		var e sqlite3.Error
+		if errors.Is(err, errBadKey) || opts.key != "" && errors.As(err, &e) && e.Code == sqlite3.ErrNotADB {
+			return nil, "", exitBadKey
+		}
+		return nil, "", exitCodeFor(err)
 	}
+	log.Printf("connected in %d ms\n", time.Since(start).Milliseconds())
+	return db, dsn, exitOK
+}
 
-	if _, err := db.Exec(`
-CREATE TABLE IF NOT EXISTS user (
- id INTEGER PRIMARY KEY AUTOINCREMENT,
- user_name TEXT NOT NULL
-);
-CREATE TABLE IF NOT EXISTS token(
- token TEXT NOT NULL,
- user_id INTEGER NOT NULL,
- device_id INTEGER NOT NULL
-);
-insert into user (user_name) values ("alice");
-insert into token(token, user_id, device_id) values ("1234", 1, 1);
+// appendDSNParams adds key=value pairs, URL-encoded, to the query part of dsn.
+func appendDSNParams(dsn string, params []string) (string, error) {
+	for _, p := range params {
+		key, value, ok := strings.Cut(p, "=")
+		if !ok || key == "" {
+			return "", fmt.Errorf("--dsn-params %q: want key=value", p)
+		}
+		sep := "&"
+		if !strings.Contains(dsn, "?") {
+			sep = "?"
+		}
+		dsn += sep + url.QueryEscape(key) + "=" + url.QueryEscape(value)
+	}
+	return dsn, nil
+}
 
-insert into user (user_name) values ("bob");
-insert into token(token, user_id, device_id) values ("4321", 2, 2);
-`); err != nil {
-		log.Panic(err)
+// withTimeout is context.WithTimeout, except that a d of 0 means no
+// timeout: the context is only cancelled with the returned func.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, d)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
-	defer cancel()
+// withStmtTimeout derives the context of a single statement from ctx.
+// go-sqlite3 interrupts the running statement when it is done, so a slow
+// query fails with context.DeadlineExceeded without stopping the run.
+func withStmtTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, d)
+}
 
-	tx, err := db.Begin()
-	if err != nil {
-		log.Panic(err)
+// logCancellation tells an operator why ctx ended, if it did:
+// the overall timeout, or a signal on top of it.
+func logCancellation(ctx, timeoutCtx context.Context) {
+	switch {
+	case ctx.Err() == nil:
+	case errors.Is(context.Cause(ctx), errCeiling):
+		log.Println("aborted by --max-stmt-ms, rolling back")
+	case timeoutCtx.Err() == context.DeadlineExceeded:
+		log.Println("timed out, rolling back")
+	default:
+		log.Println("interrupted by signal, rolling back")
 	}
-	defer tx.Rollback()
+}
+
+const tokenSQL = "select token, user_id, device_id from token as t inner join (select id from user where user_name = ?) as u on u.id = t.user_id"
 
-	stmt, err := tx.Prepare("select token, user_id, device_id from token as t inner join (select id from user where user_name = ?) as u on u.id = t.user_id")
+// queryToken is the sample's built-in query, run when no queries are
+// given on the command line; args is the user name to look up.
+func queryToken(ctx context.Context, tx querier, stmts *StmtCache, args ...interface{}) error {
+	stmt, err := stmts.Get(ctx, tx, tokenSQL)
 	if err != nil {
-		log.Printf("prepare select token got error: %s\n", err)
-		log.Panic(err)
+		category, _ := classifyError(err)
+		log.Printf("prepare select token got %s error: %s\n", category, err)
+		return err
 	}
-	defer stmt.Close()
 
 	var (
 		tokenQuery string
 		userid     int
 		deviceid   int
 	)
-	if err := stmt.QueryRowContext(ctx, "alice").Scan(&tokenQuery, &userid, &deviceid); err != nil {
-		log.Printf("query context got error: %s\n", err)
-		log.Panic(err)
+	err = stmt.QueryRowContext(ctx, args...).Scan(&tokenQuery, &userid, &deviceid)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("no matching user found for %q\n", fmt.Sprint(args...))
+		return err
 	}
-	if err := tx.Commit(); err != nil {
-		log.Panic(err)
+	if err != nil {
+		category, _ := classifyError(err)
+		log.Printf("query context got %s error: %s\n", category, err)
+		return err
 	}
 	fmt.Printf("--------- Receive: %s, %d, %d\n", tokenQuery, userid, deviceid)
-	fmt.Println("--------- complete --------")
-
-	return 1
+	return nil
 }
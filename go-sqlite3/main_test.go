@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files under testdata")
+
+// checkGolden compares got with the file testdata/name, or with -update
+// writes got to it.
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs, run go test -update to see how:\n got:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// runMain runs dbMain with args, the program name left out, and returns
+// its exit code, what it printed on stdout and the trace it wrote to a
+// --trace-file of its own.
+func runMain(t *testing.T, args ...string) (code int, stdout, trace string) {
+	t.Helper()
+	dir := t.TempDir()
+	traceFile := filepath.Join(dir, "trace.log")
+
+	out, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = saved }()
+
+	code = dbMain(append([]string{"sample", "--trace-file", traceFile}, args...))
+	out.Close()
+
+	b, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb, err := os.ReadFile(traceFile)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return code, string(b), string(tb)
+}
+
+func TestDBMainInMemory(t *testing.T) {
+	code, stdout, trace := runMain(t, "--db", ":memory:")
+	if code != exitOK {
+		t.Fatalf("exit code %d, want %d; stdout:\n%s", code, exitOK, stdout)
+	}
+	if !strings.Contains(stdout, "--------- complete --------") {
+		t.Errorf("stdout has no complete marker:\n%s", stdout)
+	}
+	for _, want := range []string{
+		`ConnectHook #1 file {""}`,
+		"ev stmt",
+		"ev profile",
+		"select token, user_id, device_id from token",
+	} {
+		if !strings.Contains(trace, want) {
+			t.Errorf("trace has no %q:\n%s", want, trace)
+		}
+	}
+}
+
+// TestDeterministicGolden runs the built-in query with --deterministic
+// twice: both traces are the same, and the same as the golden file.
+func TestDeterministicGolden(t *testing.T) {
+	var traces [2]string
+	for i := range traces {
+		code, stdout, trace := runMain(t, "--db", ":memory:", "--deterministic")
+		if code != exitOK {
+			t.Fatalf("exit code %d, want %d; stdout:\n%s", code, exitOK, stdout)
+		}
+		traces[i] = trace
+	}
+	if traces[0] != traces[1] {
+		t.Errorf("two runs differ:\n%s\n---\n%s", traces[0], traces[1])
+	}
+	checkGolden(t, "deterministic.golden", traces[0])
+
+	// The next run in the process has its clock back.
+	if runMain(t, "--db", ":memory:"); fixedClock {
+		t.Error("the clock stayed fixed after a run with --deterministic")
+	}
+}
+
+func TestDBMainExitCodes(t *testing.T) {
+	ioAccountingCode := exitUsage // a build without the VFS
+	if ioAccountingBuilt {
+		ioAccountingCode = exitOK
+	}
+	notADB := filepath.Join(t.TempDir(), "garbage.db")
+	if err := os.WriteFile(notADB, bytes.Repeat([]byte("not a database "), 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"success", []string{"--db", ":memory:"}, exitOK},
+		{"usage", []string{"--no-such-flag"}, exitUsage},
+		{"io accounting", []string{"--db", ":memory:", "--io-accounting"}, ioAccountingCode},
+		{"no rows", []string{"--db", ":memory:", "--user", "nobody"}, exitNoRows},
+		{"bad key", []string{"--db", notADB, "--key", "secret"}, exitBadKey},
+		{"bad path", []string{"--db", filepath.Join(t.TempDir(), "missing", "x.db")}, exitIO},
+		{"syntax", []string{"--db", ":memory:", "selec 1"}, exitSyntax},
+		{"no such table", []string{"--db", ":memory:", "select * from missing"}, exitSQL},
+		{"constraint", []string{"--db", ":memory:", "--fail-on-error", "insert into user (id, user_name) values (1, 'again')"}, exitConstraint},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, stdout, _ := runMain(t, tt.args...); code != tt.code {
+				t.Errorf("exit code %d, want %d; stdout:\n%s", code, tt.code, stdout)
+			}
+		})
+	}
+}
+
+func TestDBMainEmptyDatabase(t *testing.T) {
+	// The tables of the built-in query, without the demo rows of --init.
+	path := filepath.Join(t.TempDir(), "empty.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`create table user (id integer primary key autoincrement, user_name text not null);
+create table token (token text not null, user_id integer not null, device_id integer not null)`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	code, stdout, _ := runMain(t, "--db", path, "--init=false")
+	if code != exitNoRows {
+		t.Errorf("exit code %d, want %d; stdout:\n%s", code, exitNoRows, stdout)
+	}
+	if want := `no matching user found for "alice"`; !strings.Contains(logged.String(), want) {
+		t.Errorf("log has no %q:\n%s", want, logged.String())
+	}
+	if strings.Contains(logged.String(), "panic") {
+		t.Errorf("log has a panic:\n%s", logged.String())
+	}
+}
+
+func TestEventName(t *testing.T) {
+	tests := []struct {
+		code uint32
+		want string
+	}{
+		{sqlite3.TraceStmt, "stmt"},
+		{sqlite3.TraceProfile, "profile"},
+		{sqlite3.TraceRow, "row"},
+		{sqlite3.TraceClose, "close"},
+		{16, "unknown(16)"},
+		{0, "unknown(0)"},
+	}
+	for _, tt := range tests {
+		if got := eventName(tt.code); got != tt.want {
+			t.Errorf("eventName(%d) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+// slowSQL counts to a billion, far longer than any test waits.
+const slowSQL = "with recursive c(x) as (select 1 union all select x+1 from c where x < 1000000000) select count(*) from c"
+
+func TestStmtTimeout(t *testing.T) {
+	var out bytes.Buffer
+	db := openTestDB(t, newTestCollector(&out))
+
+	ctx, cancel := withStmtTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	var n int
+	err := db.QueryRowContext(ctx, slowSQL).Scan(&n)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	// Interrupted, not run to the end and then reported.
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("the query took %s after its deadline", took)
+	}
+
+	// The deadline was the statement's own: the next one runs.
+	if err := db.QueryRowContext(context.Background(), "select 1").Scan(&n); err != nil {
+		t.Errorf("next statement got %v", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	var out bytes.Buffer
+	db := openTestDB(t, newTestCollector(&out))
+	ctx, cancel := withTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var n int
+	if err := db.QueryRowContext(ctx, slowSQL).Scan(&n); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+
+	// Through dbMain: a context error is no SQLite error category.
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	code, stdout, _ := runMain(t, "--db", ":memory:", "--timeout", "200ms", slowSQL)
+	if code != exitFailure {
+		t.Errorf("exit code %d, want %d; stdout:\n%s", code, exitFailure, stdout)
+	}
+	for _, want := range []string{context.DeadlineExceeded.Error(), "timed out, rolling back"} {
+		if !strings.Contains(logged.String(), want) {
+			t.Errorf("log has no %q:\n%s", want, logged.String())
+		}
+	}
+}
+
+// TestDBMainSummaryJSON checks that with --summary json the reports of
+// the run stay off stdout, whose last line is the summary.
+func TestDBMainSummaryJSON(t *testing.T) {
+	code, stdout, _ := runMain(t, "--db", ":memory:", "--summary", "json", "--track-stmts")
+	if code != exitOK {
+		t.Fatalf("exit code %d, want %d; stdout:\n%s", code, exitOK, stdout)
+	}
+	for _, report := range []string{"prepared statements:", "statement cache:"} {
+		if strings.Contains(stdout, report) {
+			t.Errorf("stdout has %q:\n%s", report, stdout)
+		}
+	}
+	lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+	var s RunSummary
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &s); err != nil {
+		t.Errorf("last line is no summary: %s\n%s", err, stdout)
+	}
+}
+
+func TestDBMainStress(t *testing.T) {
+	code, stdout, _ := runMain(t, "--db", filepath.Join(t.TempDir(), "stress.db"), "--workers", "3", "--duration", "100ms")
+	if code != exitOK {
+		t.Fatalf("exit code %d, want %d; stdout:\n%s", code, exitOK, stdout)
+	}
+	i := strings.Index(stdout, "stress workers=3 ")
+	if i < 0 {
+		t.Fatalf("stdout has no stress line:\n%s", stdout)
+	}
+	line, _, _ := strings.Cut(stdout[i:], "\n")
+	for _, key := range []string{" ops=", " p50_ns=", " p99_ns=", " max_ns="} {
+		if !strings.Contains(line, key) {
+			t.Errorf("stress line has no %s: %s", key, line)
+		}
+	}
+	if strings.Contains(line, " ops=0 ") {
+		t.Errorf("no operations: %s", line)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"regexp"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// sqlFilter keeps the statements whose text matches --match and does not
+// match --exclude; a nil pattern lets everything through. Like sampler
+// it decides on TraceStmt, the only event with the text, and holds the
+// decision for the row and profile events of the same run. Events of no
+// statement, such as TraceClose, are always kept.
+//
+// Like rowCounter it has no lock, the TraceCollector serializes the calls.
+type sqlFilter struct {
+	match, exclude *regexp.Regexp
+	decisions      map[uintptr]sampleDecision // StmtHandle -> its current run
+}
+
+func newSQLFilter(match, exclude *regexp.Regexp) *sqlFilter {
+	return &sqlFilter{match: match, exclude: exclude, decisions: make(map[uintptr]sampleDecision)}
+}
+
+// Keep reports whether the event belongs in the output.
+func (f *sqlFilter) Keep(info sqlite3.TraceInfo) bool {
+	if f.match == nil && f.exclude == nil {
+		return true
+	}
+	switch info.EventCode {
+	case sqlite3.TraceStmt:
+		keep := (f.match == nil || f.match.MatchString(info.StmtOrTrigger)) &&
+			(f.exclude == nil || !f.exclude.MatchString(info.StmtOrTrigger))
+		f.decisions[info.StmtHandle] = sampleDecision{conn: info.ConnHandle, keep: keep}
+		return keep
+	case sqlite3.TraceClose:
+		for handle, d := range f.decisions {
+			if d.conn == info.ConnHandle {
+				delete(f.decisions, handle)
+			}
+		}
+		return true
+	}
+	d, ok := f.decisions[info.StmtHandle]
+	return !ok || d.keep
+}
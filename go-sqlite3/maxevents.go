@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// writeTruncated reports that --max-events stopped the trace, in the same
+// format as the rest of it.
+func writeTruncated(w io.Writer, format string, maxEvents int64) {
+	switch format {
+	case "text":
+		fmt.Fprintf(w, "Trace: trace truncated after %d events\n", maxEvents)
+		return
+	case "logfmt":
+		io.WriteString(w, logfmtLine("event", "truncated", "max_events", maxEvents))
+		return
+	}
+	line, _ := json.Marshal(struct {
+		Event     string `json:"event"`
+		MaxEvents int64  `json:"max_events"`
+	}{"truncated", maxEvents})
+	w.Write(append(line, '\n'))
+}
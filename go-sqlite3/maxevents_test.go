@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// TestMaxEventsConcurrent checks that callbacks racing for the last
+// slots under --max-events write no more than the cap.
+func TestMaxEventsConcurrent(t *testing.T) {
+	const max = 10
+	var out bytes.Buffer
+	c := newTraceCollector(traceSettings{
+		out:        slowWriter{&out},
+		format:     "text",
+		template:   defaultTextTemplate,
+		sampleRate: 1,
+		maxEvents:  max,
+	})
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			<-start
+			for i := 0; i < 50; i++ {
+				c.Callback(sqlite3.TraceInfo{EventCode: sqlite3.TraceRow, ConnHandle: uintptr(0x10 + g), StmtHandle: 0x20})
+			}
+		}(g)
+	}
+	close(start)
+	wg.Wait()
+
+	events, truncated := 0, 0
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		switch {
+		case strings.Contains(line, " ev row "):
+			events++
+		case strings.Contains(line, "trace truncated after"):
+			truncated++
+		}
+	}
+	if events != max || truncated != 1 {
+		t.Errorf("%d events and %d truncated lines, want %d and 1:\n%s", events, truncated, max, out.String())
+	}
+}
+
+// slowWriter sleeps in every Write, which the collector holds mu for, so
+// that the other callbacks pile up on it.
+type slowWriter struct{ w io.Writer }
+
+func (s slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	return s.w.Write(p)
+}
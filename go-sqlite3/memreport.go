@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+// startMemReports reports the size of the database every interval until
+// ctx is done, through the collector so that it lands between the trace
+// lines. go-sqlite3 has no binding for sqlite3_memory_used() and its
+// high-water mark, so the allocator cannot be watched directly; the page
+// counts at least show how the file grows and how much of it is free.
+func startMemReports(ctx context.Context, db *sql.DB, interval time.Duration, collector *TraceCollector) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+	return runEvery(ctx, interval, func(ctx context.Context, _ time.Time) {
+		var pages, pageSize, freelist int64
+		err := db.QueryRowContext(ctx,
+			"SELECT * FROM pragma_page_count(), pragma_page_size(), pragma_freelist_count()").
+			Scan(&pages, &pageSize, &freelist)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("database size got error: %s\n", err)
+			}
+			return
+		}
+		collector.DatabaseSize(pages, pageSize, freelist)
+	})
+}
+
+// writeDatabaseSize reports the page counts of the database,
+// in the same format as the rest of the trace.
+func writeDatabaseSize(w io.Writer, format string, pages, pageSize, freelist int64) {
+	switch format {
+	case "text":
+		fmt.Fprintf(w, "Trace: db size %d bytes, %d pages of %d bytes, %d free\n", pages*pageSize, pages, pageSize, freelist)
+		return
+	case "logfmt":
+		io.WriteString(w, logfmtLine("event", "db_size", "bytes", pages*pageSize, "pages", pages, "page_size", pageSize, "freelist", freelist))
+		return
+	}
+	line, _ := json.Marshal(struct {
+		Event    string `json:"event"`
+		Bytes    int64  `json:"bytes"`
+		Pages    int64  `json:"pages"`
+		PageSize int64  `json:"page_size"`
+		Freelist int64  `json:"freelist"`
+	}{"db_size", pages * pageSize, pages, pageSize, freelist})
+	w.Write(append(line, '\n'))
+}
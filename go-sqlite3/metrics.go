@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// promMetrics exports the trace stream as Prometheus metrics, labeled
+// by statement fingerprint. Like the other aggregators it learns the
+// fingerprint of a StmtHandle from its TraceStmt event, and forgets it
+// on the TraceProfile, or on the TraceClose of its connection if the
+// profile never came.
+type promMetrics struct {
+	registry *prometheus.Registry
+	duration *prometheus.HistogramVec
+	rows     *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+
+	mu  sync.Mutex
+	sql map[uintptr]stmtFingerprint // StmtHandle -> the running statement
+}
+
+type stmtFingerprint struct {
+	conn        uintptr
+	fingerprint string
+}
+
+func newPromMetrics() *promMetrics {
+	m := &promMetrics{
+		registry: prometheus.NewRegistry(),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "sqlite_statement_duration_seconds",
+			Help: "Statement run time as reported by SQLite in TraceProfile events.",
+			// 100µs up to about 6.5s, a factor 4 apart.
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 9),
+		}, []string{"fingerprint"}),
+		rows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sqlite_statement_rows_total",
+			Help: "Rows produced, counted from TraceRow events.",
+		}, []string{"fingerprint"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "sqlite_statement_errors_total",
+			Help: "Statements that ended with a database error.",
+		}, []string{"fingerprint", "code"}),
+		sql: make(map[uintptr]stmtFingerprint),
+	}
+	m.registry.MustRegister(m.duration, m.rows, m.errors)
+	return m
+}
+
+// CountDropped exports the lines a trace writer could not deliver.
+func (m *promMetrics) CountDropped(dropped func() uint64) {
+	m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "sqlite_trace_lines_dropped_total",
+		Help: "Trace lines dropped because the --trace-socket queue was full.",
+	}, func() float64 { return float64(dropped()) }))
+}
+
+func (m *promMetrics) Record(info sqlite3.TraceInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch info.EventCode {
+	case sqlite3.TraceStmt:
+		m.sql[info.StmtHandle] = stmtFingerprint{conn: info.ConnHandle, fingerprint: fingerprint(info.StmtOrTrigger)}
+	case sqlite3.TraceRow:
+		m.rows.WithLabelValues(m.sql[info.StmtHandle].fingerprint).Inc()
+	case sqlite3.TraceProfile:
+		fp := m.sql[info.StmtHandle].fingerprint
+		delete(m.sql, info.StmtHandle)
+		m.duration.WithLabelValues(fp).Observe(time.Duration(info.RunTimeNanosec).Seconds())
+		if isDBError(info.DBError) {
+			m.errors.WithLabelValues(fp, strconv.Itoa(int(info.DBError.ExtendedCode))).Inc()
+		}
+	case sqlite3.TraceClose:
+		for handle, s := range m.sql {
+			if s.conn == info.ConnHandle {
+				delete(m.sql, handle)
+			}
+		}
+	}
+}
+
+// startMetricsServer serves m on addr under /metrics until ctx is done.
+func startMetricsServer(ctx context.Context, addr string, m *promMetrics) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	srv := &http.Server{Handler: mux}
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("metrics server got error: %s\n", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	log.Printf("serving metrics on http://%s/metrics\n", ln.Addr())
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// ndjsonEvent is the line of --trace-format ndjson, the schema of the
+// warehouse tables the trace is loaded into. Every key is always there,
+// none is ever added or renamed: the load jobs depend on it.
+type ndjsonEvent struct {
+	TS              string `json:"ts"` // RFC 3339, UTC
+	Event           string `json:"event"`
+	ConnHandle      string `json:"conn_handle"`
+	StmtHandle      string `json:"stmt_handle"`
+	SQL             string `json:"sql"`
+	ExpandedSQL     string `json:"expanded_sql"`
+	RunNs           int64  `json:"run_ns"` // 0 but for profile events
+	AutoCommit      bool   `json:"auto_commit"`
+	DBErrorCode     int    `json:"db_error_code"`     // 0 unless a database error
+	DBErrorExtended int    `json:"db_error_extended"` // 0 unless a database error
+}
+
+// NDJSONFormatter writes an ndjsonEvent per event, compact. SQLITE_ROW
+// and SQLITE_DONE are no errors and leave the codes at 0.
+type NDJSONFormatter struct{}
+
+func (NDJSONFormatter) Format(info sqlite3.TraceInfo) (string, bool) {
+	ts := time.Now()
+	if fixedClock {
+		ts = time.Unix(0, 0)
+	}
+	ev := ndjsonEvent{
+		TS:          ts.UTC().Format(time.RFC3339Nano),
+		Event:       eventName(info.EventCode),
+		ConnHandle:  fmt.Sprintf("0x%x", info.ConnHandle),
+		StmtHandle:  fmt.Sprintf("0x%x", info.StmtHandle),
+		SQL:         info.StmtOrTrigger,
+		ExpandedSQL: info.ExpandedSQL,
+		RunNs:       info.RunTimeNanosec,
+		AutoCommit:  info.AutoCommit,
+	}
+	if isDBError(info.DBError) {
+		ev.DBErrorCode = int(info.DBError.Code)
+		ev.DBErrorExtended = int(info.DBError.ExtendedCode)
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Sprintf("Trace: failed to marshal event: %s\n", err), false
+	}
+	return string(line) + "\n", false
+}
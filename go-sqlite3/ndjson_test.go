@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// TestNDJSONGolden feeds the events of a statement, an error and a close
+// through a collector writing ndjson. The lines are the warehouse schema:
+// a change to testdata/trace.ndjson breaks the load jobs.
+func TestNDJSONGolden(t *testing.T) {
+	stopClock(t)
+	var out bytes.Buffer
+	c := newTraceCollector(traceSettings{
+		out:          &out,
+		format:       "ndjson",
+		template:     defaultTextTemplate,
+		sampleRate:   1,
+		warnNoExpand: true,
+	})
+	c.Connected(&sqlite3.SQLiteConn{})
+	for _, info := range []sqlite3.TraceInfo{
+		{EventCode: sqlite3.TraceStmt, ConnHandle: 0x7f10, StmtHandle: 0x7f20,
+			StmtOrTrigger: "select name from user where id = ?", ExpandedSQL: "select name from user where id = 1"},
+		{EventCode: sqlite3.TraceRow, ConnHandle: 0x7f10, StmtHandle: 0x7f20},
+		{EventCode: sqlite3.TraceRow, ConnHandle: 0x7f10, StmtHandle: 0x7f20},
+		{EventCode: sqlite3.TraceProfile, ConnHandle: 0x7f10, StmtHandle: 0x7f20,
+			StmtOrTrigger: "select name from user where id = ?", RunTimeNanosec: 1500000},
+		// no expanded SQL: --warn-no-expand has nothing to add either
+		{EventCode: sqlite3.TraceStmt, AutoCommit: true, ConnHandle: 0x7f10, StmtHandle: 0x7f30,
+			StmtOrTrigger: "insert into user (id) values (?)"},
+		{EventCode: sqlite3.TraceProfile, AutoCommit: true, ConnHandle: 0x7f10, StmtHandle: 0x7f30,
+			StmtOrTrigger: "insert into user (id) values (?)", RunTimeNanosec: 800000,
+			DBError: sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintPrimaryKey}},
+		{EventCode: sqlite3.TraceClose, ConnHandle: 0x7f10},
+	} {
+		c.Callback(info)
+	}
+	c.Totals(0)
+	checkGolden(t, "trace.ndjson", out.String())
+}
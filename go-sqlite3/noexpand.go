@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// missingExpansion returns the number of parameters of a TraceStmt event
+// that came without the expanded SQL the tracing driver asks for, and 0
+// for any other event. A statement without parameters has nothing to
+// expand, so its empty ExpandedSQL is no news.
+func missingExpansion(info sqlite3.TraceInfo) int {
+	if info.EventCode != sqlite3.TraceStmt || info.ExpandedSQL != "" {
+		return 0
+	}
+	return countPlaceholders(info.StmtOrTrigger)
+}
+
+// writeNoExpand reports, for --warn-no-expand, a statement with params
+// parameters whose expanded SQL is missing, in the same format as the
+// rest of the trace.
+func writeNoExpand(w io.Writer, format string, stmt uintptr, params int) {
+	switch format {
+	case "text":
+		fmt.Fprintf(w, "Trace: note: stmt 0x%x has %d parameters but no expanded SQL\n", stmt, params)
+		return
+	case "logfmt":
+		io.WriteString(w, logfmtLine("event", "no_expand", "stmt", fmt.Sprintf("0x%x", stmt), "params", params))
+		return
+	}
+	line, _ := json.Marshal(struct {
+		Event      string `json:"event"`
+		StmtHandle string `json:"stmt_handle"`
+		Params     int    `json:"params"`
+	}{"no_expand", fmt.Sprintf("0x%x", stmt), params})
+	w.Write(append(line, '\n'))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type openSpan struct {
+	span trace.Span
+	conn uintptr
+}
+
+// OTelTracer turns each traced statement into an OpenTelemetry span:
+// started on TraceStmt, ended on the TraceProfile of the same StmtHandle.
+// Spans are named by the statement fingerprint, which keeps the number
+// of distinct span names down to the number of distinct queries.
+//
+// The trace callback has no access to the caller's context, so the spans
+// are roots; they are joined to application traces by time, not by parent.
+type OTelTracer struct {
+	tracer trace.Tracer
+
+	mu    sync.Mutex
+	spans map[uintptr]openSpan // StmtHandle -> running statement
+}
+
+func newOTelTracer(tracer trace.Tracer) *OTelTracer {
+	return &OTelTracer{
+		tracer: tracer,
+		spans:  make(map[uintptr]openSpan),
+	}
+}
+
+// startOTLPTracer installs as the global TracerProvider, which the
+// OTelTracer of the collector takes its tracer from, one exporting the
+// spans to the traces path of the OTLP/HTTP collector at endpoint. The
+// returned shutdown pushes the spans still batched out; call it before
+// exit.
+func startOTLPTracer(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	url := strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(url))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+func (t *OTelTracer) Record(info sqlite3.TraceInfo) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch info.EventCode {
+	case sqlite3.TraceStmt:
+		sql := normalizeSQL(info.StmtOrTrigger)
+		name := fingerprint(info.StmtOrTrigger)
+		// A handle is reused only after its statement finished, so a span
+		// still open here lost its profile event; do not leak it.
+		if prev, ok := t.spans[info.StmtHandle]; ok {
+			prev.span.End()
+		}
+		_, span := t.tracer.Start(context.Background(), name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", "sqlite"),
+				attribute.String("db.statement", sql),
+			))
+		t.spans[info.StmtHandle] = openSpan{span: span, conn: info.ConnHandle}
+
+	case sqlite3.TraceProfile:
+		s, ok := t.spans[info.StmtHandle]
+		if !ok {
+			return
+		}
+		delete(t.spans, info.StmtHandle)
+
+		s.span.SetAttributes(
+			attribute.Int64("db.sqlite.run_time_ns", info.RunTimeNanosec),
+			attribute.Bool("db.sqlite.auto_commit", info.AutoCommit),
+		)
+		if isDBError(info.DBError) {
+			s.span.RecordError(info.DBError)
+			s.span.SetStatus(codes.Error, info.DBError.Error())
+		}
+		s.span.End()
+
+	case sqlite3.TraceClose:
+		for handle, s := range t.spans {
+			if s.conn == info.ConnHandle {
+				s.span.End()
+				delete(t.spans, handle)
+			}
+		}
+	}
+}
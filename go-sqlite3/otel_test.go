@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel"
+)
+
+// TestOTLPTracerExports checks that with startOTLPTracer installed the
+// spans of the collector reach the collector endpoint, on shutdown at
+// the latest.
+func TestOTLPTracerExports(t *testing.T) {
+	var posts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/v1/traces" {
+			posts.Add(1)
+		}
+	}))
+	defer srv.Close()
+
+	saved := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(saved)
+	shutdown, err := startOTLPTracer(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	c := newTestCollector(&out)
+	c.Callback(sqlite3.TraceInfo{EventCode: sqlite3.TraceStmt, ConnHandle: 0x10, StmtHandle: 0x20, StmtOrTrigger: "select 1"})
+	c.Callback(sqlite3.TraceInfo{EventCode: sqlite3.TraceProfile, ConnHandle: 0x10, StmtHandle: 0x20, RunTimeNanosec: 1000000})
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if posts.Load() == 0 {
+		t.Error("no spans were posted to /v1/traces")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// OTelMeter records the trace stream as OpenTelemetry metrics, the
+// counterpart of the spans of OTelTracer: the statement durations from
+// TraceProfile, the rows from TraceRow and the statements that ended in
+// a database error. Every measurement carries the statement fingerprint
+// and the AutoCommit flag of the event.
+type OTelMeter struct {
+	duration metric.Float64Histogram
+	rows     metric.Int64Counter
+	errors   metric.Int64Counter
+
+	mu  sync.Mutex
+	sql map[uintptr]stmtFingerprint // StmtHandle -> the running statement
+}
+
+func newOTelMeter(meter metric.Meter) (*OTelMeter, error) {
+	m := &OTelMeter{sql: make(map[uintptr]stmtFingerprint)}
+	var err error
+	m.duration, err = meter.Float64Histogram("db.statement.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Statement run time as reported by SQLite in TraceProfile events."))
+	if err != nil {
+		return nil, err
+	}
+	m.rows, err = meter.Int64Counter("db.rows",
+		metric.WithDescription("Rows produced, counted from TraceRow events."))
+	if err != nil {
+		return nil, err
+	}
+	m.errors, err = meter.Int64Counter("db.errors",
+		metric.WithDescription("Statements that ended with a database error."))
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// startOTLPMeter sets up an OTelMeter exporting to the metrics path of the
+// OTLP/HTTP collector at endpoint, e.g. http://localhost:4318. The
+// returned shutdown pushes the last measurements out; call it before exit.
+func startOTLPMeter(ctx context.Context, endpoint string) (*OTelMeter, func(context.Context) error, error) {
+	url := strings.TrimSuffix(endpoint, "/") + "/v1/metrics"
+	exporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(url))
+	if err != nil {
+		return nil, nil, err
+	}
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)))
+	m, err := newOTelMeter(provider.Meter("github.com/leslie-wang/samples/go-sqlite3"))
+	if err != nil {
+		provider.Shutdown(ctx)
+		return nil, nil, err
+	}
+	return m, provider.Shutdown, nil
+}
+
+func (m *OTelMeter) Record(info sqlite3.TraceInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The trace callback has no context of its own.
+	ctx := context.Background()
+	switch info.EventCode {
+	case sqlite3.TraceStmt:
+		m.sql[info.StmtHandle] = stmtFingerprint{conn: info.ConnHandle, fingerprint: fingerprint(info.StmtOrTrigger)}
+	case sqlite3.TraceRow:
+		m.rows.Add(ctx, 1, m.attributes(info))
+	case sqlite3.TraceProfile:
+		attrs := m.attributes(info)
+		delete(m.sql, info.StmtHandle)
+		m.duration.Record(ctx, time.Duration(info.RunTimeNanosec).Seconds(), attrs)
+		// Not any non-zero code: SQLITE_ROW and SQLITE_DONE are no errors.
+		if isDBError(info.DBError) {
+			m.errors.Add(ctx, 1, attrs, metric.WithAttributes(
+				attribute.Int("db.sqlite.error_code", int(info.DBError.ExtendedCode))))
+		}
+	case sqlite3.TraceClose:
+		// The statements whose profile never came, like promMetrics.
+		for handle, s := range m.sql {
+			if s.conn == info.ConnHandle {
+				delete(m.sql, handle)
+			}
+		}
+	}
+}
+
+func (m *OTelMeter) attributes(info sqlite3.TraceInfo) metric.MeasurementOption {
+	return metric.WithAttributes(
+		attribute.String("db.sqlite.fingerprint", m.sql[info.StmtHandle].fingerprint),
+		attribute.Bool("db.sqlite.auto_commit", info.AutoCommit),
+	)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+func TestOTelMeterForgetsStatements(t *testing.T) {
+	m, err := newOTelMeter(noop.NewMeterProvider().Meter("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Record(sqlite3.TraceInfo{EventCode: sqlite3.TraceStmt, ConnHandle: 0x10, StmtHandle: 0x20, StmtOrTrigger: "select 1"})
+	m.Record(sqlite3.TraceInfo{EventCode: sqlite3.TraceProfile, ConnHandle: 0x10, StmtHandle: 0x20})
+	if len(m.sql) != 0 {
+		t.Errorf("%d statements kept after their profile", len(m.sql))
+	}
+
+	// Interrupted: no profile, the close of the connection ends them.
+	m.Record(sqlite3.TraceInfo{EventCode: sqlite3.TraceStmt, ConnHandle: 0x10, StmtHandle: 0x21, StmtOrTrigger: "select 2"})
+	m.Record(sqlite3.TraceInfo{EventCode: sqlite3.TraceStmt, ConnHandle: 0x11, StmtHandle: 0x22, StmtOrTrigger: "select 3"})
+	m.Record(sqlite3.TraceInfo{EventCode: sqlite3.TraceClose, ConnHandle: 0x10})
+	if _, ok := m.sql[0x21]; ok || len(m.sql) != 1 {
+		t.Errorf("after the close of conn 0x10 kept %v, want the statement of conn 0x11 only", m.sql)
+	}
+}
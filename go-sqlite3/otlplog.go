@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// OTLP severity numbers, from the OpenTelemetry log data model.
+const (
+	otlpSeverityDebug = 5
+	otlpSeverityInfo  = 9
+	otlpSeverityError = 17
+)
+
+// otlpLogRecord is an OTLP LogRecord in the OTLP/JSON encoding, where
+// 64-bit integers are strings.
+type otlpLogRecord struct {
+	TimeUnixNano   string         `json:"timeUnixNano"`
+	SeverityNumber int            `json:"severityNumber"`
+	SeverityText   string         `json:"severityText"`
+	Body           otlpAnyValue   `json:"body"`
+	Attributes     []otlpKeyValue `json:"attributes"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+func otlpString(s string) otlpAnyValue { return otlpAnyValue{StringValue: &s} }
+func otlpInt(n int64) otlpAnyValue {
+	s := strconv.FormatInt(n, 10)
+	return otlpAnyValue{IntValue: &s}
+}
+func otlpBool(b bool) otlpAnyValue { return otlpAnyValue{BoolValue: &b} }
+
+// OTLPLogFormatter writes each event as an OTLP LogRecord, one JSON object
+// per line, with the fields of JSONFormatter as its attributes. Failed
+// statements are ERROR, statements and their profiles INFO, and the row
+// and close events DEBUG. The body is the statement text, or the event
+// name on the events that carry none.
+type OTLPLogFormatter struct{}
+
+func (OTLPLogFormatter) Format(info sqlite3.TraceInfo) (string, bool) {
+	rec := otlpLogRecord{
+		TimeUnixNano:   strconv.FormatInt(time.Now().UnixNano(), 10),
+		SeverityNumber: otlpSeverityInfo,
+		SeverityText:   "INFO",
+		Body:           otlpString(eventName(info.EventCode)),
+		Attributes: []otlpKeyValue{
+			{"event", otlpString(eventName(info.EventCode))},
+			{"event_code", otlpInt(int64(info.EventCode))},
+			{"auto_commit", otlpBool(info.AutoCommit)},
+			{"conn_handle", otlpString(fmt.Sprintf("0x%x", info.ConnHandle))},
+			{"stmt_handle", otlpString(fmt.Sprintf("0x%x", info.StmtHandle))},
+			{"stmt_or_trigger", otlpString(info.StmtOrTrigger)},
+			{"fingerprint", otlpString(fingerprint(info.StmtOrTrigger))},
+			{"expanded_sql", otlpString(info.ExpandedSQL)},
+			{"run_time_ns", otlpInt(info.RunTimeNanosec)},
+		},
+	}
+	if info.StmtOrTrigger != "" {
+		rec.Body = otlpString(info.StmtOrTrigger)
+	}
+	switch {
+	case isDBError(info.DBError):
+		rec.SeverityNumber, rec.SeverityText = otlpSeverityError, "ERROR"
+		rec.Attributes = append(rec.Attributes,
+			otlpKeyValue{"db_error.code", otlpInt(int64(info.DBError.Code))},
+			otlpKeyValue{"db_error.extended_code", otlpInt(int64(info.DBError.ExtendedCode))},
+			otlpKeyValue{"db_error.message", otlpString(info.DBError.Error())})
+	case info.EventCode == sqlite3.TraceRow, info.EventCode == sqlite3.TraceClose:
+		rec.SeverityNumber, rec.SeverityText = otlpSeverityDebug, "DEBUG"
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Sprintf("Trace: failed to marshal event: %s\n", err), false
+	}
+	return string(line) + "\n", false
+}
+
+// otlpLogBatch is how many records otlpLogWriter sends per request.
+const otlpLogBatch = 100
+
+// otlpLogQueue is how many batches otlpLogWriter holds while the
+// collector is slow or away.
+const otlpLogQueue = 16
+
+// otlpLogWriter ships trace lines to an OTLP/HTTP collector as logs, in
+// batches of otlpLogBatch and whatever is left on Close.
+//
+// The lines of OTLPLogFormatter are sent as they are, but for the fields
+// the collector added on top, such as request_id, which become attributes.
+// Any other line, such as the ConnectHook one, is sent as the body of an
+// INFO record.
+//
+// Like socketWriter, Write only queues: a background goroutine sends the
+// batches, so a slow or absent collector never holds up the SQLite
+// callback. A batch that does not fit in the queue, or cannot be sent,
+// is dropped and its records counted.
+type otlpLogWriter struct {
+	url     string
+	client  *http.Client
+	batches chan []json.RawMessage
+	dropped atomic.Uint64
+	done    chan struct{}
+
+	mu      sync.Mutex
+	records []json.RawMessage
+
+	closeOnce sync.Once
+}
+
+// newOTLPLogWriter sends to the logs path of the collector at endpoint.
+func newOTLPLogWriter(endpoint string) *otlpLogWriter {
+	w := &otlpLogWriter{
+		url:     strings.TrimSuffix(endpoint, "/") + "/v1/logs",
+		client:  &http.Client{Timeout: 5 * time.Second},
+		batches: make(chan []json.RawMessage, otlpLogQueue),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *otlpLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		w.records = append(w.records, toOTLPRecord(line))
+	}
+	if len(w.records) >= otlpLogBatch {
+		select {
+		case w.batches <- w.records:
+		default:
+			w.dropped.Add(uint64(len(w.records)))
+		}
+		w.records = nil
+	}
+	return len(p), nil
+}
+
+// Dropped returns the number of records lost so far, to a full queue or
+// to a failed request.
+func (w *otlpLogWriter) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+func (w *otlpLogWriter) run() {
+	defer close(w.done)
+	for records := range w.batches {
+		if err := w.send(records); err != nil {
+			log.Printf("ship %d log records got error: %s\n", len(records), err)
+			w.dropped.Add(uint64(len(records)))
+		}
+	}
+}
+
+// Close sends the records still batched and queued, giving up after
+// flushTimeout if the collector is not there to take them. Nothing may
+// be written after it.
+func (w *otlpLogWriter) Close() error {
+	const flushTimeout = 10 * time.Second
+
+	w.closeOnce.Do(func() {
+		w.mu.Lock()
+		if len(w.records) > 0 {
+			// Blocks at most until run takes a batch off the queue.
+			w.batches <- w.records
+			w.records = nil
+		}
+		w.mu.Unlock()
+		close(w.batches)
+	})
+	select {
+	case <-w.done:
+	case <-time.After(flushTimeout):
+		log.Printf("otlp logs: gave up on %d unsent batches\n", len(w.batches))
+	}
+	if n := w.Dropped(); n > 0 {
+		log.Printf("otlp logs: dropped %d records\n", n)
+	}
+	return nil
+}
+
+func (w *otlpLogWriter) send(records []json.RawMessage) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceLogs": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpKeyValue{{"service.name", otlpString("go-sqlite3-sample")}},
+			},
+			"scopeLogs": []interface{}{map[string]interface{}{
+				"scope":      map[string]string{"name": "github.com/leslie-wang/samples/go-sqlite3"},
+				"logRecords": records,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", w.url, resp.Status)
+	}
+	return nil
+}
+
+// otlpRecordFields are the keys of an OTLP/JSON LogRecord as written by
+// OTLPLogFormatter.
+var otlpRecordFields = map[string]bool{
+	"timeUnixNano": true, "severityNumber": true, "severityText": true,
+	"body": true, "attributes": true,
+}
+
+// toOTLPRecord returns line as an OTLP/JSON LogRecord.
+func toOTLPRecord(line string) json.RawMessage {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(line), &fields); err != nil || fields["severityNumber"] == nil {
+		rec, _ := json.Marshal(otlpLogRecord{
+			TimeUnixNano:   strconv.FormatInt(time.Now().UnixNano(), 10),
+			SeverityNumber: otlpSeverityInfo,
+			SeverityText:   "INFO",
+			Body:           otlpString(line),
+			Attributes:     []otlpKeyValue{},
+		})
+		return rec
+	}
+
+	var extra []string
+	for k := range fields {
+		if !otlpRecordFields[k] {
+			extra = append(extra, k)
+		}
+	}
+	if len(extra) == 0 {
+		return json.RawMessage(line)
+	}
+	sort.Strings(extra)
+	var attrs []otlpKeyValue
+	json.Unmarshal(fields["attributes"], &attrs)
+	for _, k := range extra {
+		// The added fields are strings and numbers; keep their JSON text.
+		var s string
+		if json.Unmarshal(fields[k], &s) != nil {
+			s = string(fields[k])
+		}
+		attrs = append(attrs, otlpKeyValue{k, otlpString(s)})
+		delete(fields, k)
+	}
+	fields["attributes"], _ = json.Marshal(attrs)
+	rec, _ := json.Marshal(fields)
+	return rec
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestOTLPLogWriterDoesNotBlock checks that Write returns while the
+// collector is stuck on a request, and that Close still sends every
+// record once it answers.
+func TestOTLPLogWriterDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	var received atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		var body struct {
+			ResourceLogs []struct {
+				ScopeLogs []struct {
+					LogRecords []json.RawMessage `json:"logRecords"`
+				} `json:"scopeLogs"`
+			} `json:"resourceLogs"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+			return
+		}
+		received.Add(int64(len(body.ResourceLogs[0].ScopeLogs[0].LogRecords)))
+	}))
+	defer srv.Close()
+
+	w := newOTLPLogWriter(srv.URL)
+	const lines = 3*otlpLogBatch + 7
+	start := time.Now()
+	for i := 0; i < lines; i++ {
+		w.Write([]byte("Trace: ConnectHook #1 file {\"\"}\n"))
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("Write took %s with the collector stuck", took)
+	}
+
+	close(release)
+	w.Close()
+	if got := received.Load(); got != lines {
+		t.Errorf("collector got %d records, want %d", got, lines)
+	}
+	if n := w.Dropped(); n != 0 {
+		t.Errorf("%d records dropped", n)
+	}
+}
+
+// TestOTLPLogWriterDrops checks that the records of the batches that do
+// not fit in the queue are dropped and counted.
+func TestOTLPLogWriterDrops(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { <-release }))
+	defer srv.Close()
+
+	w := newOTLPLogWriter(srv.URL)
+	line := []byte(strings.Repeat("x", 10) + "\n")
+	// One batch in flight, otlpLogQueue queued, the rest dropped.
+	for i := 0; i < (otlpLogQueue+4)*otlpLogBatch; i++ {
+		w.Write(line)
+	}
+	close(release)
+	w.Close()
+	if n := w.Dropped(); n == 0 || n%otlpLogBatch != 0 {
+		t.Errorf("%d records dropped, want whole batches", n)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RowWriter renders query results. WriteHeader is called once, before
+// the rows; Flush after the last one.
+type RowWriter interface {
+	WriteHeader(columns []string) error
+	WriteRow(values []interface{}) error
+	Flush() error
+}
+
+// columnTypesWriter is implemented by the RowWriters that show more
+// than the column names; writeRows calls it instead of WriteHeader.
+type columnTypesWriter interface {
+	WriteColumnTypes(types []*sql.ColumnType) error
+}
+
+// newRowWriter returns the RowWriter for a --format name.
+func newRowWriter(format string, w io.Writer) (RowWriter, error) {
+	switch format {
+	case "table":
+		return &tableWriter{w: w}, nil
+	case "csv":
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown --format %q, want table or csv", format)
+	}
+}
+
+// tableWriter writes tab separated lines for people to read; NULL is
+// spelled out so that it is not mistaken for an empty string.
+type tableWriter struct {
+	w io.Writer
+}
+
+func (t *tableWriter) WriteHeader(columns []string) error {
+	_, err := fmt.Fprintln(t.w, strings.Join(columns, "\t"))
+	return err
+}
+
+// WriteColumnTypes writes the header as "name (DECLTYPE, nullable)".
+// Expressions have no declared type and go-sqlite3 reports every column
+// as nullable, as SQLite's typing is per value.
+func (t *tableWriter) WriteColumnTypes(types []*sql.ColumnType) error {
+	columns := make([]string, len(types))
+	for i, ct := range types {
+		var attrs []string
+		if name := ct.DatabaseTypeName(); name != "" {
+			attrs = append(attrs, name)
+		}
+		if nullable, ok := ct.Nullable(); ok {
+			if nullable {
+				attrs = append(attrs, "nullable")
+			} else {
+				attrs = append(attrs, "not null")
+			}
+		}
+		columns[i] = ct.Name()
+		if len(attrs) > 0 {
+			columns[i] += " (" + strings.Join(attrs, ", ") + ")"
+		}
+	}
+	return t.WriteHeader(columns)
+}
+
+func (t *tableWriter) WriteRow(values []interface{}) error {
+	fields := make([]string, len(values))
+	for i, v := range values {
+		fields[i] = formatValue(v)
+	}
+	_, err := fmt.Fprintln(t.w, strings.Join(fields, "\t"))
+	return err
+}
+
+func (t *tableWriter) Flush() error { return nil }
+
+// csvWriter writes RFC 4180 CSV with a header record. NULL is an
+// empty field.
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) WriteHeader(columns []string) error {
+	return c.w.Write(columns)
+}
+
+func (c *csvWriter) WriteRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		if v != nil {
+			record[i] = formatValue(v)
+		}
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
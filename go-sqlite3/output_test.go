@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestRowWriters(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1) // every connection has a memory database of its own
+	ctx := context.Background()
+	for _, stmt := range []string{
+		"create table typed (i INTEGER, t TEXT, r REAL, b BLOB, n INTEGER)",
+		"insert into typed values (42, 'a,b', 2.5, x'6869', NULL)",
+		"insert into typed values (NULL, NULL, NULL, NULL, NULL)",
+	} {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		format, want string
+	}{
+		{"table", "i (INTEGER, nullable)\tt (TEXT, nullable)\tr (REAL, nullable)\tb (BLOB, nullable)\tn (INTEGER, nullable)\n" +
+			"42\ta,b\t2.5\thi\tNULL\n" +
+			"NULL\tNULL\tNULL\tNULL\tNULL\n"},
+		// NULL is an empty field, and the comma gets the field quoted.
+		{"csv", "i,t,r,b,n\n" +
+			"42,\"a,b\",2.5,hi,\n" +
+			",,,,\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			out, err := newRowWriter(tt.format, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := runQuery(ctx, db, out, "select i, t, r, b, n from typed order by rowid"); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("\n got %q\nwant %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{int64(42), "42"},
+		{"text", "text"},
+		{2.5, "2.5"},
+		{[]byte("hi"), "hi"},
+		{nil, "NULL"},
+	}
+	for _, tt := range tests {
+		if got := formatValue(tt.v); got != tt.want {
+			t.Errorf("formatValue(%#v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
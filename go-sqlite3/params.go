@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// parseArg gives a --arg value its bind type: an integer becomes int64,
+// the literal null becomes NULL, anything else stays a string.
+func parseArg(s string) interface{} {
+	if s == "null" {
+		return nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n
+	}
+	return s
+}
+
+// countPlaceholders returns how many values a statement binds, numbered
+// the way sqlite3_bind_parameter_count does: '?' takes the next index,
+// '?NNN' takes index NNN, and a :name, @name or $name takes the next index
+// the first time it appears and reuses it afterwards. Literals, quoted
+// identifiers and comments are skipped.
+func countPlaceholders(sql string) int {
+	max := 0
+	named := make(map[string]bool)
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(sql, i, c)
+		case c == '[':
+			end := strings.IndexByte(sql[i:], ']')
+			if end < 0 {
+				return max
+			}
+			i += end + 1
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				return max
+			}
+			i += end
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return max
+			}
+			i += end + 4
+		case c == '?':
+			start := i + 1
+			i = start
+			for i < len(sql) && isDigit(sql[i]) {
+				i++
+			}
+			if i == start {
+				max++
+			} else if n, _ := strconv.Atoi(sql[start:i]); n > max {
+				max = n
+			}
+		case (c == ':' || c == '@' || c == '$') && i+1 < len(sql) && isIdentByte(sql[i+1]):
+			start := i
+			i++
+			for i < len(sql) && isIdentByte(sql[i]) {
+				i++
+			}
+			if name := sql[start:i]; !named[name] {
+				named[name] = true
+				max++
+			}
+		case isIdentByte(c):
+			// skip whole words so that a '$' inside one is not a parameter
+			for i < len(sql) && isIdentByte(sql[i]) {
+				i++
+			}
+		default:
+			i++
+		}
+	}
+	return max
+}
+
+// boundParams counts the values SQLite substituted into an expanded
+// statement, by the type of their literal: str, int, real, blob and null.
+type boundParams struct {
+	total  int
+	byType map[string]int
+}
+
+// paramTypes is the order the types are listed in by boundParams.String.
+var paramTypes = []string{"str", "int", "real", "blob", "null"}
+
+// String formats the counts as "3 (2 str, 1 int)".
+func (p boundParams) String() string {
+	return strconv.Itoa(p.total) + " (" + p.types() + ")"
+}
+
+// types formats the counts by type as "2 str, 1 int".
+func (p boundParams) types() string {
+	var parts []string
+	for _, t := range paramTypes {
+		if n := p.byType[t]; n > 0 {
+			parts = append(parts, strconv.Itoa(n)+" "+t)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// diffParams finds the bound values by walking a statement and its
+// expanded text side by side: both are the same outside the parameters,
+// and where the statement has a parameter the expanded text has the
+// literal SQLite put in its place. A text that does not line up, which
+// should not happen, ends the count early.
+func diffParams(sql, expanded string) boundParams {
+	p := boundParams{byType: make(map[string]int)}
+	i, j := 0, 0
+	// same advances both texts over n identical bytes.
+	same := func(n int) bool {
+		if j+n > len(expanded) || sql[i:i+n] != expanded[j:j+n] {
+			return false
+		}
+		i += n
+		j += n
+		return true
+	}
+	for i < len(sql) {
+		c := sql[i]
+		var n int
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			n = skipQuoted(sql, i, c) - i
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			n = strings.IndexByte(sql[i:], '\n')
+			if n < 0 {
+				n = len(sql) - i
+			}
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			n = strings.Index(sql[i+2:], "*/")
+			if n < 0 {
+				n = len(sql) - i
+			} else {
+				n += 4
+			}
+		case c == '?' || ((c == ':' || c == '@' || c == '$') && i+1 < len(sql) && isIdentByte(sql[i+1])):
+			i++
+			for i < len(sql) && isIdentByte(sql[i]) {
+				i++
+			}
+			end, typ := skipLiteral(expanded, j)
+			if typ == "" {
+				return p
+			}
+			j = end
+			p.total++
+			p.byType[typ]++
+			continue
+		case isIdentByte(c):
+			n = 1
+			for i+n < len(sql) && isIdentByte(sql[i+n]) {
+				n++
+			}
+		default:
+			n = 1
+		}
+		if !same(n) {
+			return p
+		}
+	}
+	return p
+}
+
+// appendParams adds the bound parameter counts to a formatted trace line.
+func appendParams(format, line string, p boundParams) string {
+	switch {
+	case isJSONFormat(format):
+		types, _ := json.Marshal(p.byType)
+		return `{"params":` + strconv.Itoa(p.total) + `,"param_types":` + string(types) + "," + strings.TrimPrefix(line, "{")
+	case format == "logfmt":
+		return strings.TrimSuffix(line, "\n") + " params=" + strconv.Itoa(p.total) +
+			" param_types=" + logfmtValue(p.types()) + "\n"
+	}
+	return strings.TrimSuffix(line, "\n") + " params=" + p.String() + "\n"
+}
+
+// skipLiteral returns the end of the literal sqlite3_expanded_sql wrote
+// at start of s, and its type; "" if there is none.
+func skipLiteral(s string, start int) (int, string) {
+	if start >= len(s) {
+		return start, ""
+	}
+	switch c := s[start]; {
+	case c == '\'':
+		return skipQuoted(s, start, '\''), "str"
+	case (c == 'x' || c == 'X') && start+1 < len(s) && s[start+1] == '\'':
+		return skipQuoted(s, start+1, '\''), "blob"
+	case strings.HasPrefix(s[start:], "NULL"):
+		return start + 4, "null"
+	case c == '-' || isDigit(c):
+		i := start
+		if c == '-' {
+			i++
+		}
+		end := skipNumber(s, i)
+		if end == i {
+			return start, ""
+		}
+		if strings.ContainsAny(s[i:end], ".eE") {
+			return end, "real"
+		}
+		return end, "int"
+	}
+	return start, ""
+}
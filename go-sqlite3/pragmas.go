@@ -0,0 +1,80 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// connPragmas are the settings --log-pragmas reports for each connection.
+var connPragmas = []string{"journal_mode", "synchronous", "foreign_keys", "cache_size", "busy_timeout"}
+
+// logPragmas reads connPragmas on a new connection and writes them on
+// one line, tagged with the connection's handle like its trace events,
+// so that a consumer can join the two on it.
+func (c *TraceCollector) logPragmas(conn *sqlite3.SQLiteConn) error {
+	values := make([]string, len(connPragmas))
+	for i, name := range connPragmas {
+		rows, err := conn.Query("PRAGMA "+name, nil)
+		if err != nil {
+			return fmt.Errorf("PRAGMA %s: %w", name, err)
+		}
+		dest := make([]driver.Value, 1)
+		err = rows.Next(dest)
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("PRAGMA %s: %w", name, err)
+		}
+		values[i] = formatValue(dest[0])
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.settings.level != logInfo || c.eventsOnly() {
+		return nil
+	}
+	handle := connHandle(conn)
+	if c.ids != nil {
+		handle = c.ids.conn(handle)
+	}
+	switch c.settings.format {
+	case "text":
+		var b strings.Builder
+		for i, name := range connPragmas {
+			fmt.Fprintf(&b, " %s=%s", name, values[i])
+		}
+		fmt.Fprintf(c.settings.out, "Trace: pragmas conn 0x%x%s\n", handle, b.String())
+		return nil
+	case "logfmt":
+		kv := []interface{}{"event", "pragmas", "conn", fmt.Sprintf("0x%x", handle)}
+		for i, name := range connPragmas {
+			kv = append(kv, name, values[i])
+		}
+		io.WriteString(c.settings.out, logfmtLine(kv...))
+		return nil
+	}
+	pragmas := make(map[string]string, len(connPragmas))
+	for i, name := range connPragmas {
+		pragmas[name] = values[i]
+	}
+	line, _ := json.Marshal(struct {
+		Event      string            `json:"event"`
+		ConnHandle string            `json:"conn_handle"`
+		Pragmas    map[string]string `json:"pragmas"`
+	}{"pragmas", fmt.Sprintf("0x%x", handle), pragmas})
+	c.settings.out.Write(append(line, '\n'))
+	return nil
+}
+
+// connHandle returns the sqlite3* of conn, the ConnHandle of its trace
+// events. go-sqlite3 keeps it unexported; reflect reads the pointer
+// without dereferencing it.
+func connHandle(conn *sqlite3.SQLiteConn) uintptr {
+	return reflect.ValueOf(conn).Elem().FieldByName("db").Pointer()
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestLogPragmasConnHandle checks that the pragmas line carries the
+// handle of the connection's trace events.
+func TestLogPragmasConnHandle(t *testing.T) {
+	code, stdout, trace := runMain(t, "--db", ":memory:", "--log-pragmas")
+	if code != exitOK {
+		t.Fatalf("exit code %d, want %d; stdout:\n%s", code, exitOK, stdout)
+	}
+	m := regexp.MustCompile(`Trace: pragmas conn (0x[0-9a-f]+) journal_mode=`).FindStringSubmatch(trace)
+	if m == nil {
+		t.Fatalf("trace has no pragmas line:\n%s", trace)
+	}
+	if m[1] == "0x0" {
+		t.Fatal("the pragmas line has no handle")
+	}
+	if want := "conn " + m[1] + ", stmt"; !strings.Contains(trace, want) {
+		t.Errorf("no trace event has the handle of the pragmas line, %q:\n%s", want, trace)
+	}
+}
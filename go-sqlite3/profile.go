@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// profileBuckets are the upper bounds of the latency histogram columns;
+// anything slower lands in the last, open ended bucket.
+var profileBuckets = []time.Duration{
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+type profileStats struct {
+	count   int
+	min     time.Duration
+	max     time.Duration
+	sum     time.Duration
+	buckets []int            // len(profileBuckets)+1
+	hist    latencyHistogram // for the percentiles
+}
+
+// ProfileAggregator collects TraceProfile timings per statement fingerprint.
+//
+// A profile event does not carry the SQL text, only the statement handle,
+// so the text is remembered from the preceding TraceStmt event.
+type ProfileAggregator struct {
+	mu    sync.Mutex
+	sql   map[uintptr]string // StmtHandle -> fingerprint of the running statement
+	stats map[string]*profileStats
+}
+
+func newProfileAggregator() *ProfileAggregator {
+	return &ProfileAggregator{
+		sql:   make(map[uintptr]string),
+		stats: make(map[string]*profileStats),
+	}
+}
+
+// normalizeSQL collapses all whitespace so that the statement
+// fits on one line; unlike fingerprint it keeps the text otherwise intact.
+func normalizeSQL(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
+
+func (a *ProfileAggregator) Record(info sqlite3.TraceInfo) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch info.EventCode {
+	case sqlite3.TraceStmt:
+		a.sql[info.StmtHandle] = fingerprint(info.StmtOrTrigger)
+	case sqlite3.TraceProfile:
+		key, ok := a.sql[info.StmtHandle]
+		if !ok {
+			key = "(unknown statement)"
+		}
+		delete(a.sql, info.StmtHandle)
+
+		st := a.stats[key]
+		if st == nil {
+			st = &profileStats{buckets: make([]int, len(profileBuckets)+1)}
+			a.stats[key] = st
+		}
+		d := time.Duration(info.RunTimeNanosec)
+		if st.count == 0 || d < st.min {
+			st.min = d
+		}
+		if d > st.max {
+			st.max = d
+		}
+		st.count++
+		st.sum += d
+		st.hist.Record(d)
+
+		i := sort.Search(len(profileBuckets), func(i int) bool { return d < profileBuckets[i] })
+		st.buckets[i]++
+	}
+}
+
+// Histogram returns a copy of the timings recorded for the fingerprint
+// of sql.
+func (a *ProfileAggregator) Histogram(sql string) *latencyHistogram {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	st := a.stats[fingerprint(sql)]
+	if st == nil {
+		return &latencyHistogram{}
+	}
+	return st.hist.clone()
+}
+
+// Reset drops the timings recorded so far. The statements still running
+// are kept, so that their profiles are still attributed.
+func (a *ProfileAggregator) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.stats = make(map[string]*profileStats)
+}
+
+// Latencies returns the timings recorded, of all statements.
+func (a *ProfileAggregator) Latencies() *latencyHistogram {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	all := &latencyHistogram{}
+	for _, st := range a.stats {
+		all.Merge(&st.hist)
+	}
+	return all
+}
+
+// Report writes one row per statement, slowest total time first.
+func (a *ProfileAggregator) Report(w io.Writer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	keys := make([]string, 0, len(a.stats))
+	for k := range a.stats {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if a.stats[keys[i]].sum != a.stats[keys[j]].sum {
+			return a.stats[keys[i]].sum > a.stats[keys[j]].sum
+		}
+		return keys[i] < keys[j]
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprint(tw, "count\tmin\tmax\tsum")
+	for _, b := range profileBuckets {
+		fmt.Fprintf(tw, "\t<%s", b)
+	}
+	fmt.Fprintf(tw, "\t>=%s\tsql\n", profileBuckets[len(profileBuckets)-1])
+	for _, k := range keys {
+		st := a.stats[k]
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s", st.count, st.min, st.max, st.sum)
+		for _, n := range st.buckets {
+			fmt.Fprintf(tw, "\t%d", n)
+		}
+		fmt.Fprintf(tw, "\t%s\n", k)
+	}
+	tw.Flush()
+}
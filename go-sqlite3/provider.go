@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// ParamProvider hands out the bind values of the built-in query, one set
+// per run; ok is false once there are no more.
+type ParamProvider interface {
+	Next() (args []interface{}, ok bool)
+}
+
+// SliceProvider provides the sets of a slice, in order.
+type SliceProvider struct {
+	sets [][]interface{}
+}
+
+func newSliceProvider(sets ...[]interface{}) *SliceProvider {
+	return &SliceProvider{sets: sets}
+}
+
+func (p *SliceProvider) Next() ([]interface{}, bool) {
+	if len(p.sets) == 0 {
+		return nil, false
+	}
+	args := p.sets[0]
+	p.sets = p.sets[1:]
+	return args, true
+}
+
+// FileProvider provides one value per line of a file, surrounding
+// whitespace trimmed; blank lines are skipped. Check Err once Next
+// reports the end, and Close the provider when done.
+type FileProvider struct {
+	f       *os.File
+	scanner *bufio.Scanner
+}
+
+func newFileProvider(path string) (*FileProvider, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileProvider{f: f, scanner: bufio.NewScanner(f)}, nil
+}
+
+func (p *FileProvider) Next() ([]interface{}, bool) {
+	for p.scanner.Scan() {
+		if v := strings.TrimSpace(p.scanner.Text()); v != "" {
+			return []interface{}{v}, true
+		}
+	}
+	return nil, false
+}
+
+// Err returns the error that ended the reading, nil at the end of the file.
+func (p *FileProvider) Err() error {
+	return p.scanner.Err()
+}
+
+func (p *FileProvider) Close() error {
+	return p.f.Close()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// QueryDef is a statement to run together with its bind values.
+type QueryDef struct {
+	SQL  string
+	Args []interface{}
+}
+
+// runQuery runs an arbitrary statement inside tx and writes the rows
+// it returns to out, whatever the number and types of the columns.
+func runQuery(ctx context.Context, tx querier, out RowWriter, query string, args ...interface{}) error {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if err := writeRows(out, rows); err != nil {
+		return err
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	return rows.Close()
+}
+
+// writeRows scans every row of rows and hands the values to out, after
+// the column header. Each column is scanned into the holder go-sqlite3
+// picks from its declared type (sql.NullInt64, sql.RawBytes, ...), so
+// NULLs and BLOBs come through without scan errors. A declared type is
+// only a hint to SQLite, though: a row whose values do not fit it, such
+// as text in an INTEGER column, is scanned again into untyped values.
+func writeRows(out RowWriter, rows *sql.Rows) error {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	if tw, ok := out.(columnTypesWriter); ok {
+		err = tw.WriteColumnTypes(types)
+	} else {
+		columns := make([]string, len(types))
+		for i, ct := range types {
+			columns[i] = ct.Name()
+		}
+		err = out.WriteHeader(columns)
+	}
+	if err != nil {
+		return err
+	}
+
+	dest := make([]interface{}, len(types))
+	for i, ct := range types {
+		dest[i] = reflect.New(ct.ScanType()).Interface()
+	}
+	values := make([]interface{}, len(types))
+	untyped := make([]interface{}, len(types))
+	for i := range values {
+		untyped[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err == nil {
+			for i, d := range dest {
+				values[i] = scannedValue(d)
+			}
+		} else if err := rows.Scan(untyped...); err != nil {
+			return err
+		}
+		if err := out.WriteRow(values); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// scannedValue unwraps a scan destination made by writeRows into the
+// plain value it holds, nil for NULL.
+func scannedValue(dest interface{}) interface{} {
+	switch d := dest.(type) {
+	case *sql.RawBytes:
+		if *d == nil {
+			return nil
+		}
+		return []byte(*d)
+	case driver.Valuer: // the sql.Null* types
+		v, _ := d.Value()
+		return v
+	case **interface{}: // columns without a declared type
+		if *d == nil {
+			return nil
+		}
+		return **d
+	}
+	return reflect.ValueOf(dest).Elem().Interface()
+}
+
+// readQuery reads one statement from path, or from stdin if path is "-".
+// Surrounding whitespace and trailing semicolons are dropped, so a file
+// saved from an SQL shell runs as is; comments are left to SQLite.
+func readQuery(path string) (string, error) {
+	var (
+		b   []byte
+		err error
+	)
+	if path == "-" {
+		path = "stdin"
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	q := strings.TrimRight(strings.TrimSpace(string(b)), "; \t\r\n")
+	if q == "" {
+		return "", fmt.Errorf("%s: no SQL to run", path)
+	}
+	return q, nil
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// loadQueryConfig reads the named queries of --query-config, a JSON
+// object from name to statement and bind values:
+//
+//	{
+//	  "token": {"sql": "select token from token where user_id = ?", "args": [1]},
+//	  "users": {"sql": "select * from user"}
+//	}
+//
+// The values bind as their JSON type gives them: a number without a
+// fraction as int64, any other as float64, and null as NULL. Every
+// query must have as many values as it has placeholders.
+func loadQueryConfig(path string) (map[string]QueryDef, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var raw map[string]struct {
+		SQL  string        `json:"sql"`
+		Args []interface{} `json:"args"`
+	}
+	dec := json.NewDecoder(f)
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	defs := make(map[string]QueryDef, len(raw))
+	for name, r := range raw {
+		if strings.TrimSpace(r.SQL) == "" {
+			return nil, fmt.Errorf("%s: query %q has no sql", path, name)
+		}
+		if n := countPlaceholders(r.SQL); n != len(r.Args) {
+			return nil, fmt.Errorf("%s: query %q has %d placeholders but %d args", path, name, n, len(r.Args))
+		}
+		q := QueryDef{SQL: r.SQL}
+		for _, a := range r.Args {
+			if n, ok := a.(json.Number); ok {
+				if i, err := n.Int64(); err == nil {
+					a = i
+				} else if x, err := n.Float64(); err == nil {
+					a = x
+				}
+			}
+			q.Args = append(q.Args, a)
+		}
+		defs[name] = q
+	}
+	return defs, nil
+}
+
+// lookupQuery returns the query --run names, or an error listing the
+// names there are.
+func lookupQuery(defs map[string]QueryDef, name string) (QueryDef, error) {
+	if q, ok := defs[name]; ok {
+		return q, nil
+	}
+	names := make([]string, 0, len(defs))
+	for n := range defs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return QueryDef{}, fmt.Errorf("--run %q: no such query, want one of %s", name, strings.Join(names, ", "))
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// readOnlyDSN returns path as a URI filename, for the mode=ro parameter
+// of --read-only: go-sqlite3 hands SQLite the query part of the DSN only
+// with the file: scheme and drops it otherwise.
+func readOnlyDSN(path string) string {
+	if strings.HasPrefix(path, "file:") {
+		return path
+	}
+	return "file:" + path
+}
+
+// isReadOnly reports whether err is SQLite refusing a write, because the
+// database was opened read-only or its file or directory is not writable.
+func isReadOnly(err error) bool {
+	var serr sqlite3.Error
+	return errors.As(err, &serr) && serr.Code == sqlite3.ErrReadonly
+}
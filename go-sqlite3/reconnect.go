@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"log"
+	"sync"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// isConnLost reports whether err means the connection under a statement
+// is gone. A connection closed underneath database/sql is not noticed by
+// the pool: go-sqlite3 keeps handing it out, and every statement on it
+// fails with SQLITE_NOMEM, the code SQLite returns for a NULL handle. So
+// SQLITE_NOMEM counts only if watch knows of a connection whose handle
+// is gone; otherwise it is a real out-of-memory error.
+func isConnLost(err error, watch *connWatch) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) {
+		return true
+	}
+	var serr sqlite3.Error
+	return errors.As(err, &serr) && serr.Code == sqlite3.ErrNomem && watch.lost()
+}
+
+// reconnect replaces a lost connection. The transaction on it, if any, is
+// rolled back, which hands the connection back to the pool; dropping the
+// idle connections then closes it, and the next statement gets a new one,
+// with a ConnectHook run of its own in the trace. The returned runner and
+// transaction replace the old ones; what the old transaction did is
+// undone, and it is up to the caller to run it again.
+func reconnect(ctx context.Context, db *sql.DB, tx txn, opts *options) (querier, txn, error) {
+	if tx != nil {
+		tx.Rollback() // fails on the lost connection, nothing to do about it
+	}
+	db.SetMaxIdleConns(0)
+	db.SetMaxIdleConns(opts.maxIdle)
+	if opts.noTx {
+		return db, nil, nil
+	}
+
+	tx, err := beginTx(ctx, db, opts.txMode)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.requestID != "" {
+		if err := tagRequest(WithRequestID(ctx, opts.requestID), tx); err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		}
+	}
+	return tx, tx, nil
+}
+
+// reconnectOnce runs query with run on runner and, if it lost its
+// connection, reconnects and runs it once more on the new one. It returns
+// the runner and transaction to go on with. Unlike runQueries it runs
+// nothing before query again: it is for the built-in token queries,
+// which only read, so the rollback took none of their work with it.
+func reconnectOnce(ctx context.Context, db *sql.DB, watch *connWatch, runner querier, tx txn, opts *options,
+	query string, run func(querier) error) (querier, txn, error) {
+	err := run(runner)
+	if !isConnLost(err, watch) {
+		return runner, tx, err
+	}
+	log.Printf("query %q lost its connection, reconnecting: %s\n", query, err)
+	runner, tx, err = reconnect(ctx, db, tx, opts)
+	if err != nil {
+		log.Printf("reconnect got error: %s\n", err)
+		return nil, nil, err
+	}
+	return runner, tx, run(runner)
+}
+
+// runQueries runs opts.queries in order with run, in tx, or on the pool if
+// tx is nil, and returns the transaction to commit: a lost connection
+// replaces it. The query that lost its connection runs again on a new
+// one; in a transaction so do the queries before it, whose work went with
+// the rollback, so that the commit has all of it or the run fails. Each
+// query may lose its connection once.
+//
+// With --fail-on-error, or once ctx is done, the first failure stops the
+// run and is returned as stop; otherwise the queries left still run, and
+// the first failure is returned as first.
+func runQueries(ctx context.Context, db *sql.DB, watch *connWatch, tx txn, opts *options,
+	run func(context.Context, querier, QueryDef) error) (_ txn, first, stop error) {
+	var runner querier = db
+	if tx != nil {
+		runner = tx
+	}
+	var succeeded, failed int
+	lostAt := -1 // the last query that lost its connection
+	for i := 0; i < len(opts.queries); i++ {
+		q := opts.queries[i]
+		err := run(ctx, runner, q)
+		if i > lostAt && isConnLost(err, watch) {
+			lostAt = i
+			log.Printf("query %q lost its connection, reconnecting: %s\n", q.SQL, err)
+			r, t, rerr := reconnect(ctx, db, tx, opts)
+			if rerr != nil {
+				log.Printf("reconnect got error: %s\n", rerr)
+				return nil, nil, rerr
+			}
+			runner, tx = r, t
+			if tx != nil && i > 0 {
+				log.Printf("the rollback undid %d queries before it, running them again\n", i)
+				i, succeeded, failed, first = -1, 0, 0, nil
+				continue
+			}
+			err = run(ctx, runner, q)
+		}
+		if err == nil {
+			succeeded++
+			continue
+		}
+		failed++
+		category, _ := classifyError(err)
+		log.Printf("query %q got %s error: %s\n", q.SQL, category, err)
+		// A cancelled run fails the queries left too, so stop anyway.
+		if opts.failOnError || ctx.Err() != nil {
+			log.Printf("queries: %d succeeded, %d failed, %d not run\n", succeeded, failed, len(opts.queries)-succeeded-failed)
+			return tx, nil, err
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	if len(opts.queries) > 0 {
+		log.Printf("queries: %d succeeded, %d failed\n", succeeded, failed)
+	}
+	return tx, first, nil
+}
+
+// connWatch wraps the driver of dbMain to keep the connections it opened
+// until database/sql closes them, for isConnLost to tell a connection
+// closed underneath the pool from a real SQLITE_NOMEM.
+type connWatch struct {
+	driver.Driver
+	mu   sync.Mutex
+	open map[*watchedConn]bool
+}
+
+func newConnWatch(drv driver.Driver) *connWatch {
+	return &connWatch{Driver: drv, open: make(map[*watchedConn]bool)}
+}
+
+func (w *connWatch) Open(dsn string) (driver.Conn, error) {
+	conn, err := w.Driver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	c := &watchedConn{conn: conn, watch: w}
+	w.mu.Lock()
+	w.open[c] = true
+	w.mu.Unlock()
+	return c, nil
+}
+
+// lost reports whether a connection database/sql has not closed lost its
+// handle all the same. go-sqlite3's Ping tells that one by ErrBadConn.
+func (w *connWatch) lost() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for c := range w.open {
+		if c.Ping(context.Background()) == driver.ErrBadConn {
+			return true
+		}
+	}
+	return false
+}
+
+// watchedConn passes everything on to the connection it wraps, the way
+// ledgerConn does, and leaves the watch when database/sql closes it.
+type watchedConn struct {
+	conn  driver.Conn
+	watch *connWatch
+}
+
+func (c *watchedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *watchedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+}
+
+func (c *watchedConn) Close() error {
+	c.watch.mu.Lock()
+	delete(c.watch.open, c)
+	c.watch.mu.Unlock()
+	return c.conn.Close()
+}
+
+func (c *watchedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *watchedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c *watchedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+func (c *watchedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
+
+func (c *watchedConn) Ping(ctx context.Context) error {
+	return c.conn.(driver.Pinger).Ping(ctx)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+func TestRunQueriesReconnects(t *testing.T) {
+	for _, noTx := range []bool{false, true} {
+		name := "tx"
+		if noTx {
+			name = "no tx"
+		}
+		t.Run(name, func(t *testing.T) {
+			var conns []*sqlite3.SQLiteConn
+			watch := newConnWatch(&sqlite3.SQLiteDriver{
+				ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+					conns = append(conns, conn)
+					return nil
+				},
+			})
+			db := sql.OpenDB(driverConnector{drv: watch, dsn: filepath.Join(t.TempDir(), "test.db")})
+			defer db.Close()
+			ctx := context.Background()
+			if _, err := db.ExecContext(ctx, "create table t (x integer)"); err != nil {
+				t.Fatal(err)
+			}
+
+			opts := &options{noTx: noTx, txMode: "deferred", maxIdle: 2, failOnError: true, queries: []QueryDef{
+				{SQL: "insert into t values (1)"},
+				{SQL: "insert into t values (2)"},
+				{SQL: "insert into t values (3)"},
+			}}
+			var tx txn
+			if !noTx {
+				var err error
+				if tx, err = beginTx(ctx, db, opts.txMode); err != nil {
+					t.Fatal(err)
+				}
+			}
+			closed := false
+			tx, first, stop := runQueries(ctx, db, watch, tx, opts, func(ctx context.Context, runner querier, q QueryDef) error {
+				if q.SQL == "insert into t values (2)" && !closed {
+					// Closed underneath database/sql, which goes on handing it out.
+					closed = true
+					conns[len(conns)-1].Close()
+				}
+				_, err := runner.ExecContext(ctx, q.SQL, q.Args...)
+				return err
+			})
+			if first != nil || stop != nil {
+				t.Fatalf("runQueries: first %v, stop %v", first, stop)
+			}
+			if tx != nil {
+				if err := tx.Commit(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(conns) != 2 {
+				t.Errorf("%d connections opened, want 2", len(conns))
+			}
+
+			var n, sum int
+			if err := db.QueryRowContext(ctx, "select count(*), sum(x) from t").Scan(&n, &sum); err != nil {
+				t.Fatal(err)
+			}
+			if n != 3 || sum != 6 {
+				t.Errorf("%d rows, sum %d; want every query run once: 3 rows, sum 6", n, sum)
+			}
+		})
+	}
+}
+
+func TestIsConnLost(t *testing.T) {
+	watch := newConnWatch(&sqlite3.SQLiteDriver{})
+	db := sql.OpenDB(driverConnector{drv: watch, dsn: ":memory:"})
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	nomem := sqlite3.Error{Code: sqlite3.ErrNomem}
+	if isConnLost(nomem, watch) {
+		t.Error("SQLITE_NOMEM with every connection open counts as lost")
+	}
+	if !isConnLost(sql.ErrConnDone, watch) {
+		t.Error("sql.ErrConnDone does not count as lost")
+	}
+	if isConnLost(sqlite3.Error{Code: sqlite3.ErrConstraint}, watch) {
+		t.Error("SQLITE_CONSTRAINT counts as lost")
+	}
+}
+
+func TestReconnectOnce(t *testing.T) {
+	for _, noTx := range []bool{false, true} {
+		name := "tx"
+		if noTx {
+			name = "no tx"
+		}
+		t.Run(name, func(t *testing.T) {
+			var conns []*sqlite3.SQLiteConn
+			watch := newConnWatch(&sqlite3.SQLiteDriver{
+				ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+					conns = append(conns, conn)
+					return nil
+				},
+			})
+			db := sql.OpenDB(driverConnector{drv: watch, dsn: filepath.Join(t.TempDir(), "test.db")})
+			defer db.Close()
+			ctx := context.Background()
+			if _, err := db.ExecContext(ctx, "create table t (x integer); insert into t values (1), (2)"); err != nil {
+				t.Fatal(err)
+			}
+
+			opts := &options{noTx: noTx, txMode: "deferred", maxIdle: 2}
+			var (
+				tx     txn
+				runner querier = db
+			)
+			if !noTx {
+				var err error
+				if tx, err = beginTx(ctx, db, opts.txMode); err != nil {
+					t.Fatal(err)
+				}
+				runner = tx
+			}
+			// Closed underneath database/sql, which goes on handing it out.
+			conns[len(conns)-1].Close()
+
+			const query = "select count(*) from t"
+			var n, runs int
+			runner, tx, err := reconnectOnce(ctx, db, watch, runner, tx, opts, query, func(runner querier) error {
+				runs++
+				return runner.QueryRowContext(ctx, query).Scan(&n)
+			})
+			if err != nil {
+				t.Fatalf("reconnectOnce: %v", err)
+			}
+			if runs != 2 || n != 2 {
+				t.Errorf("%d runs, count %d; want 2 runs, the second on a new connection, count 2", runs, n)
+			}
+			if (tx != nil) == noTx || (noTx && runner != querier(db)) {
+				t.Errorf("runner %T, tx %v with noTx %t", runner, tx, noTx)
+			}
+			if tx != nil {
+				tx.Rollback()
+			}
+		})
+	}
+}
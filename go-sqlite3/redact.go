@@ -0,0 +1,133 @@
+package main
+
+import "strings"
+
+// redactExpandedSQL replaces the literals in an expanded statement
+// with '?', so that bound user data (names, tokens, ...) does not
+// leave the box with the trace.
+//
+// String literals follow SQL quoting, a quote inside the literal is
+// written twice:
+//
+//	'it''s'
+//
+// Blob literals (x'00ff') and numbers are replaced as well. Quoted
+// identifiers ("name", [name], `name`) and comments are copied as they are.
+func redactExpandedSQL(expanded string) string {
+	var b strings.Builder
+	b.Grow(len(expanded))
+
+	for i := 0; i < len(expanded); {
+		c := expanded[i]
+		switch {
+		case c == '\'':
+			i = skipQuoted(expanded, i, '\'')
+			b.WriteByte('?')
+		case (c == 'x' || c == 'X') && i+1 < len(expanded) && expanded[i+1] == '\'' &&
+			(i == 0 || !isIdentByte(expanded[i-1])):
+			i = skipQuoted(expanded, i+1, '\'')
+			b.WriteByte('?')
+		case c == '"' || c == '`':
+			end := skipQuoted(expanded, i, c)
+			b.WriteString(expanded[i:end])
+			i = end
+		case c == '[':
+			end := strings.IndexByte(expanded[i:], ']')
+			if end < 0 {
+				end = len(expanded)
+			} else {
+				end += i + 1
+			}
+			b.WriteString(expanded[i:end])
+			i = end
+		case c == '-' && strings.HasPrefix(expanded[i:], "--"):
+			end := strings.IndexByte(expanded[i:], '\n')
+			if end < 0 {
+				end = len(expanded)
+			} else {
+				end += i
+			}
+			b.WriteString(expanded[i:end])
+			i = end
+		case c == '/' && strings.HasPrefix(expanded[i:], "/*"):
+			end := strings.Index(expanded[i+2:], "*/")
+			if end < 0 {
+				end = len(expanded)
+			} else {
+				end += i + 4
+			}
+			b.WriteString(expanded[i:end])
+			i = end
+		case isDigit(c) || (c == '.' && i+1 < len(expanded) && isDigit(expanded[i+1])):
+			if i > 0 && isIdentByte(expanded[i-1]) {
+				// part of an identifier such as t1
+				b.WriteByte(c)
+				i++
+				continue
+			}
+			i = skipNumber(expanded, i)
+			b.WriteByte('?')
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// skipQuoted returns the index just past the literal opened by the quote
+// at s[start]. A doubled quote is an escaped quote, not the end.
+func skipQuoted(s string, start int, quote byte) int {
+	for i := start + 1; i < len(s); i++ {
+		if s[i] != quote {
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == quote {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return len(s)
+}
+
+// skipNumber returns the index just past the numeric literal at s[start]:
+// integers, decimals, exponents and 0x hex.
+func skipNumber(s string, start int) int {
+	i := start
+	if strings.HasPrefix(s[i:], "0x") || strings.HasPrefix(s[i:], "0X") {
+		i += 2
+		for i < len(s) && isHexDigit(s[i]) {
+			i++
+		}
+		return i
+	}
+	for i < len(s) && (isDigit(s[i]) || s[i] == '.') {
+		i++
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		if j < len(s) && isDigit(s[j]) {
+			i = j
+			for i < len(s) && isDigit(s[i]) {
+				i++
+			}
+		}
+	}
+	return i
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
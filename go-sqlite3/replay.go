@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// replay re-runs the statements of a JSON trace (SQLITE_TRACE_FORMAT=json)
+// against db, in their original order. Only stmt events are executed;
+// profile, row and close events, and lines that are not trace events at
+// all, are skipped. A failing statement is logged and counted, and the
+// replay goes on with the next one.
+//
+// The trace must have been captured with --no-redact: redacted statements
+// have '?' where the values were and cannot run.
+func replay(ctx context.Context, db *sql.DB, r io.Reader) error {
+	// One connection for everything, so that a captured BEGIN ... COMMIT
+	// wraps the same statements again.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var replayed, failed int
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var ev jsonTraceEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			continue
+		}
+		if ev.Event != "stmt" && ev.EventCode != sqlite3.TraceStmt {
+			continue
+		}
+		// Trigger invocations are traced as an SQL comment with no expansion.
+		query := ev.ExpandedSQL
+		if strings.TrimSpace(query) == "" {
+			continue
+		}
+
+		replayed++
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			failed++
+			log.Printf("replay %q got error: %s\n", query, err)
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	fmt.Printf("--------- replayed %d statements, %d errors\n", replayed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d replayed statements failed", failed, replayed)
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying id, for tagRequest.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func requestIDFrom(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok && id != ""
+}
+
+// requestMarkerSQL is run by tagRequest; the trace callback recognizes it
+// and reads the bound ID back from its expanded text.
+const requestMarkerSQL = "SELECT ? AS request_id"
+
+// tagRequest tells the trace which request the statements that follow on
+// q's connection belong to, if ctx carries a request ID. The trace
+// callback never sees the Go context, so the ID travels through SQLite as
+// a bound value of a marker statement.
+//
+// q must stay on one connection: a *sql.Tx or a *sql.Conn. On a *sql.DB
+// the marker and the queries after it could run on different pooled
+// connections, and the IDs would be attributed to the wrong queries.
+func tagRequest(ctx context.Context, q querier) error {
+	id, ok := requestIDFrom(ctx)
+	if !ok {
+		return nil
+	}
+	_, err := q.ExecContext(ctx, requestMarkerSQL, id)
+	return err
+}
+
+// requestRegistry maps a connection to the request ID it was last tagged
+// with. The ID stays until the connection is tagged again or closed, so
+// statements run on the connection after a request ended without a new
+// tag, e.g. by other code sharing the pool, are still printed with it.
+//
+// Like rowCounter it has no lock, the TraceCollector serializes the calls.
+type requestRegistry struct {
+	ids map[uintptr]string // ConnHandle -> request ID
+}
+
+func newRequestRegistry() *requestRegistry {
+	return &requestRegistry{ids: make(map[uintptr]string)}
+}
+
+// Record returns the request ID of the connection of the event, if any.
+func (r *requestRegistry) Record(info sqlite3.TraceInfo) string {
+	switch info.EventCode {
+	case sqlite3.TraceStmt:
+		if info.StmtOrTrigger == requestMarkerSQL {
+			// The expanded text has the ID as a quoted SQL string.
+			lit := strings.TrimSuffix(strings.TrimPrefix(info.ExpandedSQL, "SELECT '"), "' AS request_id")
+			r.ids[info.ConnHandle] = strings.ReplaceAll(lit, "''", "'")
+		}
+	case sqlite3.TraceClose:
+		id := r.ids[info.ConnHandle]
+		delete(r.ids, info.ConnHandle)
+		return id
+	}
+	return r.ids[info.ConnHandle]
+}
+
+// appendRequestID adds id to a formatted trace line: as req=<id> for the
+// text formats, as a request_id member of the JSON object.
+func appendRequestID(format, line, id string) string {
+	if isJSONFormat(format) {
+		v, _ := json.Marshal(id)
+		return `{"request_id":` + string(v) + "," + strings.TrimPrefix(line, "{")
+	}
+	return strings.TrimSuffix(line, "\n") + " req=" + logfmtValue(id) + "\n"
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestAppendRequestID checks that the JSON line stays JSON whatever the
+// id has in it.
+func TestAppendRequestID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string // what a JSON reader gets back
+	}{
+		{"req-1", "req-1"},
+		{"nul \x00", "nul \x00"},
+		{"bell \a", "bell \a"},
+		{"tab\t\"quoted\"", "tab\t\"quoted\""},
+		{"emoji \U0001F600", "emoji \U0001F600"},
+		{"bad \xff", "bad �"},
+	}
+	for _, tt := range tests {
+		line := appendRequestID("json", `{"event":"stmt"}`+"\n", tt.id)
+		var got struct {
+			RequestID string `json:"request_id"`
+			Event     string `json:"event"`
+		}
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Errorf("%q: %s: %s", tt.id, err, line)
+			continue
+		}
+		if got.RequestID != tt.want || got.Event != "stmt" {
+			t.Errorf("%q: got request_id %q, event %q: %s", tt.id, got.RequestID, got.Event, line)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// isBusy reports whether err is SQLite telling us to come back later.
+func isBusy(err error) bool {
+	_, retriable := classifyError(err)
+	return retriable
+}
+
+// withRetry calls fn up to attempts times while it fails with SQLITE_BUSY
+// or SQLITE_LOCKED, sleeping baseDelay, 2*baseDelay, 4*baseDelay, ...
+// in between. Any other error, and the last busy one, is returned as is.
+func withRetry(ctx context.Context, attempts int, baseDelay time.Duration, fn func() error) error {
+	delay := baseDelay
+	for i := 1; ; i++ {
+		err := fn()
+		if err == nil || !isBusy(err) || i >= attempts {
+			return err
+		}
+		log.Printf("attempt %d of %d got error: %s, retrying in %s\n", i, attempts, err, delay)
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+// RingBuffer keeps the last lines written to it and drops older ones,
+// so tracing into it costs memory for a fixed number of lines only.
+// It is an io.Writer for the trace formats, which write one line per call.
+type RingBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	next  int // index the next line goes to
+	full  bool
+}
+
+func newRingBuffer(capacity int) *RingBuffer {
+	return &RingBuffer{lines: make([]string, capacity)}
+}
+
+// Add stores line, overwriting the oldest one once the buffer is full.
+func (r *RingBuffer) Add(line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lines[r.next] = line
+	r.next++
+	if r.next == len(r.lines) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+func (r *RingBuffer) Write(p []byte) (int, error) {
+	r.Add(string(p))
+	return len(p), nil
+}
+
+// Dump writes the stored lines to w, oldest first.
+func (r *RingBuffer) Dump(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.full {
+		for _, line := range r.lines[r.next:] {
+			io.WriteString(w, line)
+		}
+	}
+	for _, line := range r.lines[:r.next] {
+		io.WriteString(w, line)
+	}
+}
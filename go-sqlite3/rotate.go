@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// rotatingWriter appends to path until it would grow past maxBytes,
+// then renames it to path.1 (path.2 on the next roll over, and so on)
+// and starts a fresh file. The highest number is the most recent file.
+type rotatingWriter struct {
+	mu       sync.Mutex // trace callbacks come from any goroutine
+	path     string
+	maxBytes int64
+	file     *os.File
+	size     int64
+	next     int // suffix for the next roll over
+}
+
+// newRotatingWriter opens path for appending. maxBytes <= 0 never rotates.
+func newRotatingWriter(path string, maxBytes int64) (io.WriteCloser, error) {
+	w := &rotatingWriter{path: path, maxBytes: maxBytes, next: 1}
+	// Continue the numbering of an earlier run instead of overwriting it.
+	for {
+		if _, err := os.Stat(w.rolledName(w.next)); err != nil {
+			break
+		}
+		w.next++
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotatingWriter) rolledName(n int) string {
+	return fmt.Sprintf("%s.%d", w.path, n)
+}
+
+func (w *rotatingWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.size = st.Size()
+	return nil
+}
+
+func (w *rotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(w.path, w.rolledName(w.next)); err != nil {
+		return err
+	}
+	w.next++
+	return w.open()
+}
+
+// Write never splits p: a write that does not fit goes to a new file,
+// so one trace line always stays in one file.
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	if w.maxBytes > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxBytes {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *rotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+type rowCount struct {
+	conn uintptr
+	rows int
+}
+
+// rowCounter counts TraceRow events per statement. The count of a
+// statement is handed out, and forgotten, on its TraceProfile event,
+// or on TraceClose of its connection if the profile never came.
+//
+// It has no lock of its own, the TraceCollector serializes the calls.
+type rowCounter struct {
+	counts map[uintptr]rowCount
+}
+
+func newRowCounter() *rowCounter {
+	return &rowCounter{counts: make(map[uintptr]rowCount)}
+}
+
+// Record returns the flushed statement handles and their row counts.
+func (c *rowCounter) Record(info sqlite3.TraceInfo) map[uintptr]int {
+	switch info.EventCode {
+	case sqlite3.TraceRow:
+		rc := c.counts[info.StmtHandle]
+		rc.conn = info.ConnHandle
+		rc.rows++
+		c.counts[info.StmtHandle] = rc
+	case sqlite3.TraceProfile:
+		if rc, ok := c.counts[info.StmtHandle]; ok {
+			delete(c.counts, info.StmtHandle)
+			return map[uintptr]int{info.StmtHandle: rc.rows}
+		}
+	case sqlite3.TraceClose:
+		var flushed map[uintptr]int
+		for handle, rc := range c.counts {
+			if rc.conn != info.ConnHandle {
+				continue
+			}
+			if flushed == nil {
+				flushed = make(map[uintptr]int)
+			}
+			flushed[handle] = rc.rows
+			delete(c.counts, handle)
+		}
+		return flushed
+	}
+	return nil
+}
+
+// writeRowCount reports the rows one statement scanned,
+// in the same format as the rest of the trace.
+func writeRowCount(w io.Writer, format string, stmt uintptr, rows int) {
+	switch format {
+	case "text":
+		fmt.Fprintf(w, "Trace: stmt 0x%x %d rows scanned\n", stmt, rows)
+		return
+	case "logfmt":
+		io.WriteString(w, logfmtLine("stmt", fmt.Sprintf("0x%x", stmt), "rows_scanned", rows))
+		return
+	}
+	line, _ := json.Marshal(struct {
+		StmtHandle  string `json:"stmt_handle"`
+		RowsScanned int    `json:"rows_scanned"`
+	}{fmt.Sprintf("0x%x", stmt), rows})
+	w.Write(append(line, '\n'))
+}
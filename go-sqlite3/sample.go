@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math/rand"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+type sampleDecision struct {
+	conn uintptr
+	keep bool
+}
+
+// sampler keeps a random share of the statements in the output. The
+// decision is made on TraceStmt and holds for the row and profile events
+// of the same run, so a statement shows up either whole or not at all.
+// Events of no statement, such as TraceClose, are always kept.
+//
+// Like rowCounter it has no lock, the TraceCollector serializes the calls;
+// that is also what makes sharing one rand.Rand safe.
+type sampler struct {
+	rate      float64
+	rng       *rand.Rand
+	decisions map[uintptr]sampleDecision // StmtHandle -> its current run
+}
+
+func newSampler(rate float64, seed int64) *sampler {
+	return &sampler{
+		rate:      rate,
+		rng:       rand.New(rand.NewSource(seed)),
+		decisions: make(map[uintptr]sampleDecision),
+	}
+}
+
+// Keep reports whether the event belongs in the output.
+func (s *sampler) Keep(info sqlite3.TraceInfo) bool {
+	if s.rate >= 1 {
+		return true
+	}
+	switch info.EventCode {
+	case sqlite3.TraceStmt:
+		d := sampleDecision{conn: info.ConnHandle, keep: s.rng.Float64() < s.rate}
+		s.decisions[info.StmtHandle] = d
+		return d.keep
+	case sqlite3.TraceClose:
+		for handle, d := range s.decisions {
+			if d.conn == info.ConnHandle {
+				delete(s.decisions, handle)
+			}
+		}
+		return true
+	}
+	d, ok := s.decisions[info.StmtHandle]
+	return !ok || d.keep
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+)
+
+// savepointName is the savepoint --savepoint opens around every query.
+const savepointName = "sp1"
+
+// withSavepoint runs fn inside a savepoint called name on tx, nested in
+// the transaction tx belongs to. The SAVEPOINT, RELEASE and ROLLBACK TO
+// statements go through tx.ExecContext, so the trace shows them as +Tx+
+// events around the query's own.
+//
+// With rollback, or when fn fails, the work of fn is undone with ROLLBACK
+// TO, which keeps the savepoint open, so a RELEASE follows it; the
+// enclosing transaction goes on either way. Without --savepoint, name is
+// empty and fn just runs.
+func withSavepoint(ctx context.Context, tx querier, name string, rollback bool, fn func() error) error {
+	if name == "" {
+		return fn()
+	}
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+	err := fn()
+	if err != nil || rollback {
+		if _, rerr := tx.ExecContext(ctx, "ROLLBACK TO "+name); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	if _, rerr := tx.ExecContext(ctx, "RELEASE "+name); rerr != nil && err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+// ensureSchema makes a fresh database runnable: it creates the tables
+// the built-in query joins and the demo users with a token each.
+//
+// Every step is a no-op when its table or row already exists, so an
+// existing database such as test.db, whose tables carry more columns,
+// is left untouched.
+func ensureSchema(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS user (
+ id INTEGER PRIMARY KEY AUTOINCREMENT,
+ user_name TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS token(
+ token TEXT NOT NULL,
+ user_id INTEGER NOT NULL,
+ device_id INTEGER NOT NULL
+);
+`); err != nil {
+		return err
+	}
+
+	demo := []struct {
+		userName string
+		token    string
+		deviceID int
+	}{
+		{"alice", "1234", 1},
+		{"bob", "4321", 2},
+	}
+	for _, d := range demo {
+		if _, err := db.ExecContext(ctx,
+			`insert into user (user_name) select ? where not exists (select 1 from user where user_name = ?)`,
+			d.userName, d.userName); err != nil {
+			return err
+		}
+		if _, err := db.ExecContext(ctx,
+			`insert into token(token, user_id, device_id)
+ select ?, u.id, ? from user as u
+ where u.user_name = ? and not exists (select 1 from token where user_id = u.id)`,
+			d.token, d.deviceID, d.userName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
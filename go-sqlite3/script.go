@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// triggerRe matches the start of a CREATE TRIGGER, whose body holds
+// semicolons of its own up to the closing END.
+var triggerRe = regexp.MustCompile(`(?is)^create\s+(?:temp\s+|temporary\s+)?trigger\b`)
+
+// splitStatements cuts a script at the semicolons that end statements:
+// not those inside string literals, quoted identifiers or comments, and
+// not those inside the BEGIN ... END of a trigger. Statements that are
+// empty, or only comments, are left out.
+func splitStatements(script string) []string {
+	var (
+		stmts    []string
+		start    int
+		lastWord string // last keyword or identifier seen, for END
+	)
+	flush := func(end int) {
+		stmt := strings.TrimSpace(script[start:end])
+		if fingerprint(stmt) != "" {
+			stmts = append(stmts, stmt)
+		}
+		start = end + 1
+		lastWord = ""
+	}
+
+	for i := 0; i < len(script); {
+		c := script[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			i = skipQuoted(script, i, c)
+		case c == '[':
+			end := strings.IndexByte(script[i:], ']')
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 1
+			}
+		case c == '-' && strings.HasPrefix(script[i:], "--"):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end
+			}
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 4
+			}
+		case isIdentByte(c):
+			w := i
+			for i < len(script) && isIdentByte(script[i]) {
+				i++
+			}
+			lastWord = script[w:i]
+		case c == ';':
+			stmt := strings.TrimSpace(stripLeadingComments(script[start:i]))
+			if !triggerRe.MatchString(stmt) || strings.EqualFold(lastWord, "end") {
+				flush(i)
+			}
+			i++
+		default:
+			i++
+		}
+	}
+	flush(len(script))
+	return stmts
+}
+
+// stripLeadingComments drops the comments and whitespace before the
+// first token of s.
+func stripLeadingComments(s string) string {
+	for {
+		s = strings.TrimSpace(s)
+		switch {
+		case strings.HasPrefix(s, "--"):
+			end := strings.IndexByte(s, '\n')
+			if end < 0 {
+				return ""
+			}
+			s = s[end:]
+		case strings.HasPrefix(s, "/*"):
+			end := strings.Index(s, "*/")
+			if end < 0 {
+				return ""
+			}
+			s = s[end+2:]
+		default:
+			return s
+		}
+	}
+}
+
+// runScript executes the statements of script one by one, so that each is
+// traced on its own, and prints the rows each one affected. It stops at the
+// first failing statement.
+//
+// Everything runs on one connection: a script that sets a PRAGMA, creates
+// a temporary table or runs BEGIN ... COMMIT expects the statements after
+// it to see the effect.
+func runScript(ctx context.Context, db *sql.DB, script string) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for i, stmt := range splitStatements(script) {
+		res, err := conn.ExecContext(ctx, stmt)
+		if err != nil {
+			return fmt.Errorf("statement %d: %w", i+1, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("statement %d: %w", i+1, err)
+		}
+		fmt.Printf("--------- statement %d: %d rows affected\n", i+1, n)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// selfTest checks that a tracing driver delivers events: it runs SELECT 1
+// on an in-memory database through one, with a collector of its own that
+// writes nowhere, and checks that the collector got at least one event.
+// It prints the outcome and returns the exit code of --self-test.
+func selfTest(opts *options) int {
+	collector := newTraceCollector(traceSettings{
+		out:        io.Discard,
+		format:     "text",
+		template:   defaultTextTemplate,
+		sampleRate: 1,
+	})
+	drv := newTracingDriver(&options{eventMask: opts.eventMask, busyTimeoutMs: -1}, collector, collector.Callback)
+
+	db := sql.OpenDB(driverConnector{drv: drv, dsn: ":memory:"})
+	defer db.Close()
+	if _, ok := db.Driver().(*sqlite3.SQLiteDriver); !ok {
+		fmt.Printf("self-test: FAILED, the driver is a %T, not go-sqlite3's\n", db.Driver())
+		return exitFailure
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var one int
+	if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		fmt.Printf("self-test: FAILED, SELECT 1: %s\n", err)
+		return exitFailure
+	}
+	// The events are delivered in the statement's own call, so they are
+	// all in by now.
+	n := collector.emitted.Load()
+	if n == 0 {
+		fmt.Println("self-test: FAILED, SELECT 1 gave no trace event; --trace needs stmt, profile or row for it")
+		return exitFailure
+	}
+	fmt.Printf("self-test: ok, %d trace events\n", n)
+	return exitOK
+}
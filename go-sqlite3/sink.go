@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// TraceSink stores trace events in a table of their own database, so a
+// run can be examined afterwards with plain SQL:
+//
+//	SELECT sql, run_ns FROM trace_events WHERE event = 'profile' ORDER BY run_ns DESC;
+//
+// It opens that database with the plain "sqlite3" driver: tracing the
+// inserts would feed the sink its own events.
+type TraceSink struct {
+	db        *sql.DB
+	batchSize int
+
+	mu      sync.Mutex
+	pending []traceRow
+	err     error // first failed flush, returned by Close
+}
+
+type traceRow struct {
+	ts       string
+	event    string
+	conn     string
+	stmt     string
+	sql      string
+	expanded string
+	runNanos int64
+	err      sql.NullString
+}
+
+func newTraceSink(path string, batchSize int) (*TraceSink, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS trace_events(
+ ts TEXT NOT NULL,
+ event TEXT NOT NULL,
+ conn TEXT NOT NULL,
+ stmt TEXT NOT NULL,
+ sql TEXT NOT NULL,
+ expanded TEXT NOT NULL,
+ run_ns INTEGER NOT NULL,
+ err TEXT
+)`); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return &TraceSink{db: db, batchSize: batchSize}, nil
+}
+
+// Insert queues info and writes the queue out once it holds a full batch.
+func (s *TraceSink) Insert(info sqlite3.TraceInfo) {
+	row := traceRow{
+		ts:       time.Now().UTC().Format(time.RFC3339Nano),
+		event:    eventName(info.EventCode),
+		conn:     fmt.Sprintf("0x%x", info.ConnHandle),
+		stmt:     fmt.Sprintf("0x%x", info.StmtHandle),
+		sql:      info.StmtOrTrigger,
+		expanded: info.ExpandedSQL,
+		runNanos: info.RunTimeNanosec,
+	}
+	if isDBError(info.DBError) {
+		row.err = sql.NullString{String: info.DBError.Error(), Valid: true}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, row)
+	if len(s.pending) >= s.batchSize {
+		s.flushLocked()
+	}
+}
+
+// flushLocked writes the queued rows in one transaction.
+func (s *TraceSink) flushLocked() {
+	if len(s.pending) == 0 {
+		return
+	}
+	rows := s.pending
+	s.pending = nil
+
+	err := func() error {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		stmt, err := tx.Prepare(`INSERT INTO trace_events(ts, event, conn, stmt, sql, expanded, run_ns, err) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, r := range rows {
+			if _, err := stmt.Exec(r.ts, r.event, r.conn, r.stmt, r.sql, r.expanded, r.runNanos, r.err); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}()
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+// Close flushes what is left and closes the sink database.
+func (s *TraceSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.flushLocked()
+	if err := s.db.Close(); err != nil && s.err == nil {
+		s.err = err
+	}
+	return s.err
+}
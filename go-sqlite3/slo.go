@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// sloTarget is one --slo entry: the latency percentile to check and the
+// most it may be; max is the 100th percentile.
+type sloTarget struct {
+	name       string
+	percentile float64
+	limit      time.Duration
+}
+
+// parseSLO parses an --slo value such as "p99=50ms".
+func parseSLO(s string) (sloTarget, error) {
+	name, limit, ok := strings.Cut(s, "=")
+	if !ok {
+		return sloTarget{}, fmt.Errorf("--slo %q: want name=duration, e.g. p99=50ms", s)
+	}
+	t := sloTarget{name: name}
+	switch name {
+	case "p50":
+		t.percentile = 50
+	case "p95":
+		t.percentile = 95
+	case "p99":
+		t.percentile = 99
+	case "max":
+		t.percentile = 100
+	default:
+		return sloTarget{}, fmt.Errorf("--slo %q: unknown percentile %q, want p50, p95, p99 or max", s, name)
+	}
+	var err error
+	if t.limit, err = time.ParseDuration(limit); err != nil {
+		return sloTarget{}, fmt.Errorf("--slo %q: %w", s, err)
+	}
+	return t, nil
+}
+
+// checkSLOs writes one line per target telling whether the latencies
+// meet it, and reports whether they met them all.
+// Without any latency there is nothing to hold against a target, which
+// counts as a failure: the profile events are probably not traced.
+//
+// The histogram knows a percentile up to the bucket it falls in, so a
+// target fails only when the whole bucket is over it: a run that met
+// its targets never fails, but one whose percentile is over by less
+// than 1/subBuckets of it may pass. max is exact. The line shows the
+// bucket when the percentile is not exact.
+func checkSLOs(w io.Writer, latencies *latencyHistogram, targets []sloTarget) bool {
+	ok := true
+	for _, t := range targets {
+		lo, hi := latencies.PercentileRange(t.percentile)
+		result := "passed"
+		switch {
+		case latencies.Count() == 0:
+			result = "FAILED, no profile events"
+		case lo > t.limit:
+			result = "FAILED"
+		}
+		if result != "passed" {
+			ok = false
+		}
+		got := hi.String()
+		if lo != hi {
+			got = lo.String() + ".." + got
+		}
+		fmt.Fprintf(w, "SLO %s <= %s: %s (%s %s over %d statements)\n", t.name, t.limit, result, t.name, got, latencies.Count())
+	}
+	return ok
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckSLOs(t *testing.T) {
+	// 99 statements right at 50ms, one slow one.
+	var h latencyHistogram
+	for i := 0; i < 99; i++ {
+		h.Record(50 * time.Millisecond)
+	}
+	h.Record(200 * time.Millisecond)
+
+	tests := []struct {
+		slo  string
+		want bool
+	}{
+		// Met exactly: the bucket of 50ms reaches past it, which is no failure.
+		{"p99=50ms", true},
+		{"p50=50ms", true},
+		{"p99=45ms", false},
+		// Over, but by less than the bucket: passes, as documented.
+		{"p99=49ms", true},
+		{"max=200ms", true},
+		{"max=199ms", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.slo, func(t *testing.T) {
+			target, err := parseSLO(tt.slo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			if got := checkSLOs(&out, &h, []sloTarget{target}); got != tt.want {
+				t.Errorf("checkSLOs = %t, want %t: %s", got, tt.want, out.String())
+			}
+		})
+	}
+
+	var out bytes.Buffer
+	if checkSLOs(&out, &latencyHistogram{}, []sloTarget{{name: "p99", percentile: 99, limit: time.Second}}) {
+		t.Error("passed without any latency")
+	}
+	if !strings.Contains(out.String(), "no profile events") {
+		t.Errorf("line: %s", out.String())
+	}
+}
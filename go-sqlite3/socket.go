@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// socketBuffer is how many lines socketWriter holds while the
+// collector on the other end is slow or away.
+const socketBuffer = 4096
+
+// socketWriter streams trace lines to a Unix domain socket. Write only
+// queues the line, so a slow or absent collector never holds up the
+// SQLite callback; when the queue is full the line is dropped and counted.
+// A background goroutine dials, writes, and redials with backoff whenever
+// the collector goes away.
+type socketWriter struct {
+	path    string
+	lines   chan []byte
+	dropped atomic.Uint64
+	done    chan struct{}
+
+	closeOnce sync.Once
+}
+
+func newSocketWriter(path string) *socketWriter {
+	w := &socketWriter{
+		path:  path,
+		lines: make(chan []byte, socketBuffer),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *socketWriter) Write(p []byte) (int, error) {
+	// p may be reused by the caller once Write returns.
+	line := append([]byte(nil), p...)
+	select {
+	case w.lines <- line:
+	default:
+		w.dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+// Dropped returns the number of lines lost to a full queue so far.
+func (w *socketWriter) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+func (w *socketWriter) run() {
+	defer close(w.done)
+
+	var conn net.Conn
+	delay := 100 * time.Millisecond
+	for line := range w.lines {
+		for conn == nil {
+			c, err := net.Dial("unix", w.path)
+			if err == nil {
+				conn, delay = c, 100*time.Millisecond
+				break
+			}
+			log.Printf("dial trace socket got error: %s, retrying in %s\n", err, delay)
+			time.Sleep(delay)
+			if delay < 5*time.Second {
+				delay *= 2
+			}
+		}
+		if _, err := conn.Write(line); err != nil {
+			// The collector went away; the line is lost, the next one redials.
+			log.Printf("write trace socket got error: %s\n", err)
+			conn.Close()
+			conn = nil
+			w.dropped.Add(1)
+		}
+	}
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// Close sends what is still queued, giving up after flushTimeout
+// if the collector is not there to take it.
+func (w *socketWriter) Close() error {
+	const flushTimeout = 2 * time.Second
+
+	w.closeOnce.Do(func() { close(w.lines) })
+	select {
+	case <-w.done:
+	case <-time.After(flushTimeout):
+		log.Printf("trace socket: gave up on %d unsent lines\n", len(w.lines))
+	}
+	if n := w.Dropped(); n > 0 {
+		log.Printf("trace socket: dropped %d lines\n", n)
+	}
+	return nil
+}
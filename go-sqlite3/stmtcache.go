@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sync"
+)
+
+type stmtKey struct {
+	db  querier
+	sql string
+}
+
+// StmtCache keeps the statements it prepared, by SQL text, so that a
+// query run again reuses its *sql.Stmt instead of being prepared anew;
+// the trace then shows the statement handle staying the same.
+//
+// A statement belongs to the database, transaction or connection that
+// prepared it, so the cache is keyed by that too. Statements prepared in
+// a transaction are closed by database/sql when it ends; using them after
+// that fails with sql.ErrTxDone.
+type StmtCache struct {
+	mu     sync.Mutex
+	stmts  map[stmtKey]*sql.Stmt
+	hits   int
+	misses int
+}
+
+func newStmtCache() *StmtCache {
+	return &StmtCache{stmts: make(map[stmtKey]*sql.Stmt)}
+}
+
+// Get returns the statement prepared for query on db, preparing it with
+// ctx on the first call. ctx only bounds the prepare, not later runs.
+func (c *StmtCache) Get(ctx context.Context, db querier, query string) (*sql.Stmt, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := stmtKey{db: db, sql: query}
+	if stmt, ok := c.stmts[key]; ok {
+		c.hits++
+		return stmt, nil
+	}
+	c.misses++
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	c.stmts[key] = stmt
+	return stmt, nil
+}
+
+// Close closes every cached statement. It must run before the database
+// is closed: a statement left open keeps its connection busy and the
+// close can fail with "database is locked". It returns the first error.
+func (c *StmtCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var first error
+	for key, stmt := range c.stmts {
+		if err := stmt.Close(); err != nil && first == nil {
+			first = err
+		}
+		delete(c.stmts, key)
+	}
+	return first
+}
+
+// Report writes the hit and miss counts.
+func (c *StmtCache) Report(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "statement cache: %d hits, %d misses\n", c.hits, c.misses)
+}
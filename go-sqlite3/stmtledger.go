@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// stmtLedger is the open/close ledger of --track-stmts: every statement
+// prepared at the driver level stays in it until its Close. The trace API
+// reports neither the prepare nor the finalize, and go-sqlite3 does not
+// expose the sqlite3_stmt behind a driver.Stmt, so the ledger is kept by
+// ledgerDriver and keyed by the driver statement rather than the
+// StmtHandle of the events.
+//
+// Like rowCounter it has no lock, the TraceCollector serializes the calls.
+type stmtLedger struct {
+	open     map[driver.Stmt]string // statement -> SQL
+	prepared int
+}
+
+func newStmtLedger() *stmtLedger {
+	return &stmtLedger{open: make(map[driver.Stmt]string)}
+}
+
+// leaked returns the SQL of the statements still open, sorted.
+func (l *stmtLedger) leaked() []string {
+	sqls := make([]string, 0, len(l.open))
+	for _, query := range l.open {
+		sqls = append(sqls, query)
+	}
+	sort.Strings(sqls)
+	return sqls
+}
+
+func (c *TraceCollector) stmtPrepared(s driver.Stmt, query string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ledger.open[s] = query
+	c.ledger.prepared++
+}
+
+func (c *TraceCollector) stmtClosed(s driver.Stmt) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.ledger.open, s)
+}
+
+// LeakedStmts writes a line for every prepared statement not closed yet,
+// and returns how many there are; 0 also without --track-stmts.
+func (c *TraceCollector) LeakedStmts(w io.Writer) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ledger == nil {
+		return 0
+	}
+	leaked := c.ledger.leaked()
+	for _, query := range leaked {
+		fmt.Fprintf(w, "leaked prepared statement: %s\n", query)
+	}
+	fmt.Fprintf(w, "prepared statements: %d, leaked: %d\n", c.ledger.prepared, len(leaked))
+	return len(leaked)
+}
+
+// ledgerDriver wraps the tracing driver for --track-stmts, recording the
+// statements its connections prepare and close in the collector's ledger.
+type ledgerDriver struct {
+	driver.Driver
+	collector *TraceCollector
+}
+
+func (d *ledgerDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &ledgerConn{conn: conn, collector: d.collector}, nil
+}
+
+// ledgerConn passes everything on to the go-sqlite3 connection. It has
+// ExecContext and QueryContext of its own so that database/sql does not
+// fall back to preparing a statement for every Exec and Query, which
+// would run them differently than without --track-stmts.
+type ledgerConn struct {
+	conn      driver.Conn
+	collector *TraceCollector
+}
+
+func (c *ledgerConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *ledgerConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	s, err := c.conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	stmt := &ledgerStmt{Stmt: s, collector: c.collector}
+	c.collector.stmtPrepared(stmt, query)
+	return stmt, nil
+}
+
+func (c *ledgerConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *ledgerConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *ledgerConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c *ledgerConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+func (c *ledgerConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
+
+func (c *ledgerConn) Ping(ctx context.Context) error {
+	return c.conn.(driver.Pinger).Ping(ctx)
+}
+
+// ledgerStmt leaves the ledger on Close.
+type ledgerStmt struct {
+	driver.Stmt
+	collector *TraceCollector
+}
+
+func (s *ledgerStmt) Close() error {
+	s.collector.stmtClosed(s)
+	return s.Stmt.Close()
+}
+
+func (s *ledgerStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.Stmt.(driver.StmtExecContext).ExecContext(ctx, args)
+}
+
+func (s *ledgerStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.Stmt.(driver.StmtQueryContext).QueryContext(ctx, args)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// stressToken runs the built-in query for userName in a loop on each of
+// workers goroutines, all on the pool of db in autocommit mode, until
+// duration has passed or ctx is done. Every worker runs its queries one
+// after the other, so the pool opens up to workers connections and their
+// trace callbacks run concurrently into the one collector.
+//
+// It prints a single key=value line like benchToken: the total operations,
+// their spread over the workers and the latency percentiles. Unlike
+// benchToken's, the latencies are measured around each query, as the
+// caller sees them, pool waits included. Each worker keeps them in a
+// latencyHistogram of its own, so a long duration costs no memory, and
+// the percentiles are up to 1/16 high like those of the summary.
+//
+// The first error stops all workers and is returned.
+func stressToken(ctx context.Context, db *sql.DB, userName string, workers int, duration, stmtTimeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	ops := make([]int, workers)
+	latencies := make([]latencyHistogram, workers)
+	start := time.Now()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			var (
+				token    string
+				userid   int
+				deviceid int
+			)
+			for ctx.Err() == nil {
+				qctx, qcancel := withStmtTimeout(ctx, stmtTimeout)
+				began := time.Now()
+				err := db.QueryRowContext(qctx, tokenSQL, userName).Scan(&token, &userid, &deviceid)
+				took := time.Since(began)
+				qcancel()
+				// The query the duration cut short is no failure.
+				if err != nil && ctx.Err() != nil && !errors.Is(err, sql.ErrNoRows) {
+					return
+				}
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+						log.Printf("stress worker %d got error: %s\n", w, err)
+					}
+					mu.Unlock()
+					cancel()
+					return
+				}
+				ops[w]++
+				latencies[w].Record(took)
+			}
+		}(w)
+	}
+	wg.Wait()
+	total := time.Since(start)
+	if firstErr != nil {
+		return firstErr
+	}
+
+	var all latencyHistogram
+	for i := range latencies {
+		all.Merge(&latencies[i])
+	}
+	perWorker := append([]int(nil), ops...)
+	sort.Ints(perWorker)
+
+	fmt.Printf("stress workers=%d total_ns=%d ops=%d ops_per_sec=%.1f worker_ops_min=%d worker_ops_p50=%d worker_ops_max=%d p50_ns=%d p90_ns=%d p99_ns=%d max_ns=%d\n",
+		workers, total.Nanoseconds(), all.Count(), float64(all.Count())/total.Seconds(),
+		perWorker[0], perWorker[(len(perWorker)-1)/2], perWorker[len(perWorker)-1],
+		all.Percentile(50).Nanoseconds(), all.Percentile(90).Nanoseconds(),
+		all.Percentile(99).Nanoseconds(), all.Max().Nanoseconds())
+	return nil
+}
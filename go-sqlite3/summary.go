@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// RunSummary is the summary of a run for machines: `--summary json`
+// writes it as one JSON object at exit, so that a CI job can check the
+// aggregates without parsing the trace lines.
+type RunSummary struct {
+	Statements            int `json:"statements"`
+	Rows                  int `json:"rows"`
+	AutoCommitStatements  int `json:"autocommit_statements"`
+	TransactionStatements int `json:"transaction_statements"`
+
+	// Connections are the distinct ConnHandles the statements ran on;
+	// ConnectionReuses the times they went back to one, see connTracker.
+	Connections      int `json:"connections"`
+	ConnectionReuses int `json:"connection_reuses"`
+
+	// Errors counts the failed statements by extended result code.
+	Errors map[int]int `json:"errors"`
+
+	// Latency is taken from the TraceProfile events, in nanoseconds. The
+	// percentiles come off a latencyHistogram, up to 1/16 high.
+	Latency struct {
+		Count int   `json:"count"`
+		P50   int64 `json:"p50_ns"`
+		P90   int64 `json:"p90_ns"`
+		P99   int64 `json:"p99_ns"`
+		Max   int64 `json:"max_ns"`
+	} `json:"latency"`
+}
+
+// RunSummary returns the summary of everything traced so far.
+func (c *TraceCollector) RunSummary() RunSummary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := RunSummary{
+		Statements:            c.counts.totalStmts,
+		Rows:                  c.counts.totalRows,
+		AutoCommitStatements:  c.autoCommitStmts,
+		TransactionStatements: c.txStmts,
+		Connections:           c.conns.conns,
+		ConnectionReuses:      c.conns.reuses,
+		Errors:                make(map[int]int, len(c.dbErrors.counts)),
+	}
+	for code, n := range c.dbErrors.counts {
+		s.Errors[code] = n
+	}
+	latencies := c.profiles.Latencies()
+	s.Latency.Count = latencies.Count()
+	if latencies.Count() > 0 {
+		s.Latency.P50 = latencies.Percentile(50).Nanoseconds()
+		s.Latency.P90 = latencies.Percentile(90).Nanoseconds()
+		s.Latency.P99 = latencies.Percentile(99).Nanoseconds()
+		s.Latency.Max = latencies.Max().Nanoseconds()
+	}
+	return s
+}
+
+// ResetSummary zeroes what RunSummary reports, for /reset of the admin
+// server; the summary and profile report at exit count from there too.
+func (c *TraceCollector) ResetSummary() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.counts.totalStmts, c.counts.totalRows = 0, 0
+	c.autoCommitStmts, c.txStmts = 0, 0
+	c.dbErrors.counts = make(map[int]int)
+	c.profiles.Reset()
+	c.hours = newHourBuckets(c.hours.loc)
+	c.conns.Reset()
+}
+
+// writeRunSummary writes the RunSummary of c to path, or to stdout if
+// path is empty.
+func writeRunSummary(path string, c *TraceCollector) error {
+	var w io.Writer = os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	line, err := json.Marshal(c.RunSummary())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
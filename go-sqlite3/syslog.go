@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// syslogFacilities are the --syslog-facility names, with their codes
+// as in <syslog.h>.
+var syslogFacilities = map[string]int{
+	"kern": 0 << 3, "user": 1 << 3, "mail": 2 << 3, "daemon": 3 << 3,
+	"auth": 4 << 3, "syslog": 5 << 3, "lpr": 6 << 3, "news": 7 << 3,
+	"uucp": 8 << 3, "cron": 9 << 3, "authpriv": 10 << 3, "ftp": 11 << 3,
+	"local0": 16 << 3, "local1": 17 << 3, "local2": 18 << 3, "local3": 19 << 3,
+	"local4": 20 << 3, "local5": 21 << 3, "local6": 22 << 3, "local7": 23 << 3,
+}
+
+func parseSyslogFacility(name string) (int, error) {
+	if f, ok := syslogFacilities[name]; ok {
+		return f, nil
+	}
+	names := make([]string, 0, len(syslogFacilities))
+	for n := range syslogFacilities {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return 0, fmt.Errorf("unknown --syslog-facility %q, want one of %s", name, strings.Join(names, ", "))
+}
+
+// errorLineWriter is a trace writer that tells the lines of failed
+// statements apart, as the syslog writer does by their severity.
+type errorLineWriter interface {
+	io.Writer
+	WriteError(line string) error
+}
@@ -0,0 +1,28 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// syslogWriter is not available here: log/syslog is Unix only.
+type syslogWriter struct{}
+
+func newSyslogWriter(facility int, tag string) (*syslogWriter, error) {
+	return nil, fmt.Errorf("syslog is not supported on %s", runtime.GOOS)
+}
+
+func (s *syslogWriter) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("syslog is not supported on %s", runtime.GOOS)
+}
+
+func (s *syslogWriter) WriteError(line string) error {
+	_, err := s.Write([]byte(line))
+	return err
+}
+
+func (s *syslogWriter) Close() error {
+	return nil
+}
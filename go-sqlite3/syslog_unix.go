@@ -0,0 +1,38 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"log/syslog"
+	"strings"
+)
+
+// syslogWriter sends every trace line to the local syslog as a message
+// of its own: at LOG_ERR for a statement that failed, else at LOG_INFO.
+type syslogWriter struct {
+	w *syslog.Writer
+}
+
+// newSyslogWriter connects to the local syslog daemon.
+func newSyslogWriter(facility int, tag string) (*syslogWriter, error) {
+	w, err := syslog.New(syslog.Priority(facility)|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogWriter{w: w}, nil
+}
+
+func (s *syslogWriter) Write(p []byte) (int, error) {
+	if err := s.w.Info(strings.TrimSuffix(string(p), "\n")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *syslogWriter) WriteError(line string) error {
+	return s.w.Err(strings.TrimSuffix(line, "\n"))
+}
+
+func (s *syslogWriter) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bufio"
+	"os"
+)
+
+// teeFile is the file side of --tee: buffered, as the console is what
+// is watched live, and flushed when closed.
+type teeFile struct {
+	*bufio.Writer
+	f *os.File
+}
+
+func newTeeFile(path string) (*teeFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &teeFile{Writer: bufio.NewWriter(f), f: f}, nil
+}
+
+func (t *teeFile) Close() error {
+	if err := t.Flush(); err != nil {
+		t.f.Close()
+		return err
+	}
+	return t.f.Close()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// defaultTextTemplate is the line of TextFormatter as a --template, the
+// starting point for one of your own.
+const defaultTextTemplate = `Trace: t=+{{printf "%.3f" .Elapsed}}s ev {{.Event}} {{.Mode}} conn {{hex .ConnHandle}}, stmt {{hex .StmtHandle}} {{"{"}}{{printf "%q" .StmtOrTrigger}}}{{.ExpandedText}}{{.RunTimeText}}{{.DBErrorText}}`
+
+// templateFuncs are the functions a --template can call, next to the
+// fields of textEvent and the TraceInfo it embeds.
+var templateFuncs = template.FuncMap{
+	"eventName":   eventName,
+	"fingerprint": fingerprint,
+	"hex":         func(h uintptr) string { return fmt.Sprintf("0x%x", h) },
+	"runMs": func(ns int64) float64 {
+		return float64(ns) / float64(time.Millisecond)
+	},
+	"isDBError": isDBError,
+}
+
+// TemplateFormatter renders an event with a text/template, parsed once by
+// newTemplateFormatter and executed with the textEvent of each event. A
+// line that does not end in a newline gets one.
+type TemplateFormatter struct {
+	tmpl *template.Template
+}
+
+// newTemplateFormatter parses a --template, so that an error in it shows
+// at startup rather than on the first event.
+func newTemplateFormatter(text string) (*TemplateFormatter, error) {
+	tmpl, err := template.New("line").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("--template: %w", err)
+	}
+	return &TemplateFormatter{tmpl: tmpl}, nil
+}
+
+func (f *TemplateFormatter) Format(info sqlite3.TraceInfo) (string, bool) {
+	return f.execute(newTextEvent(info, false))
+}
+
+// FormatRedacted executes the template with .Redacted set, and the
+// .ExpandedText of TextFormatter.FormatRedacted.
+func (f *TemplateFormatter) FormatRedacted(info sqlite3.TraceInfo) (string, bool) {
+	return f.execute(newTextEvent(info, true))
+}
+
+func (f *TemplateFormatter) execute(t textEvent) (string, bool) {
+	var b strings.Builder
+	if err := f.tmpl.Execute(&b, t); err != nil {
+		return fmt.Sprintf("Trace: failed to execute template: %s\n", err), false
+	}
+	line := b.String()
+	if !strings.HasSuffix(line, "\n") {
+		line += "\n"
+	}
+	return line, false
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// throughput counts statements and rows, since the last snapshot and
+// in total. Like rowCounter it has no lock, the TraceCollector
+// serializes the calls.
+type throughput struct {
+	stmts, rows           int // since the last snapshot
+	totalStmts, totalRows int
+}
+
+func (t *throughput) Record(info sqlite3.TraceInfo) {
+	switch info.EventCode {
+	case sqlite3.TraceStmt:
+		t.stmts++
+		t.totalStmts++
+	case sqlite3.TraceRow:
+		t.rows++
+		t.totalRows++
+	}
+}
+
+// writeThroughput reports one snapshot, or the totals when kind is
+// "total", in the same format as the rest of the trace.
+func writeThroughput(w io.Writer, format, kind string, elapsed time.Duration, stmts, rows int) {
+	switch format {
+	case "text":
+		fmt.Fprintf(w, "Trace: %s %s statements %d rows %d\n", kind, elapsed.Round(time.Millisecond), stmts, rows)
+		return
+	case "logfmt":
+		io.WriteString(w, logfmtLine("event", kind, "elapsed_ms", elapsed.Milliseconds(), "statements", stmts, "rows", rows))
+		return
+	}
+	line, _ := json.Marshal(struct {
+		Event      string `json:"event"`
+		ElapsedMs  int64  `json:"elapsed_ms"`
+		Statements int    `json:"statements"`
+		Rows       int    `json:"rows"`
+	}{kind, elapsed.Milliseconds(), stmts, rows})
+	w.Write(append(line, '\n'))
+}
+
+// startThroughputReports writes the statements and rows traced every
+// interval until ctx is done. The returned function stops the reports,
+// then writes the totals of the run.
+func startThroughputReports(ctx context.Context, interval time.Duration, collector *TraceCollector) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	start := time.Now()
+	last := start
+	stop := runEvery(ctx, interval, func(_ context.Context, now time.Time) {
+		collector.Snapshot(now.Sub(last))
+		last = now
+	})
+	return func() {
+		stop()
+		collector.Totals(time.Since(start))
+	}
+}
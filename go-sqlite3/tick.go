@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// runEvery calls fn every interval, in its own goroutine, until ctx is
+// done. The returned function stops it and waits for a running fn.
+func runEvery(ctx context.Context, interval time.Duration, fn func(ctx context.Context, now time.Time)) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				fn(ctx, now)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
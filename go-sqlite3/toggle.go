@@ -0,0 +1,21 @@
+package main
+
+// StartTrace turns the trace back on after StopTrace. A collector starts
+// out tracing.
+func (c *TraceCollector) StartTrace() {
+	c.stopped.Store(false)
+}
+
+// StopTrace drops every event from now on, until StartTrace: nothing is
+// written and nothing is recorded, the aggregates included, so that a
+// phase of a run can be left out of the trace. The ConnectHook lines
+// are still written. A statement cut in two by the toggle is seen only
+// in part.
+func (c *TraceCollector) StopTrace() {
+	c.stopped.Store(true)
+}
+
+// Tracing reports whether events are traced, i.e. StopTrace is not in effect.
+func (c *TraceCollector) Tracing() bool {
+	return !c.stopped.Load()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// truncatedSuffix ends an SQL text cut by --max-sql-len.
+const truncatedSuffix = "…(truncated)"
+
+// truncateSQL cuts s to its first n runes, never inside a multi-byte
+// character, and marks the cut. It returns s unchanged if it is short
+// enough, and reports whether it cut.
+func truncateSQL(s string, n int) (string, bool) {
+	if utf8.RuneCountInString(s) <= n {
+		return s, false
+	}
+	runes := 0
+	for i := range s {
+		if runes == n {
+			return s[:i] + truncatedSuffix, true
+		}
+		runes++
+	}
+	return s, false
+}
+
+// appendSQLLen adds the original length, in runes, of a truncated
+// statement to a formatted trace line.
+func appendSQLLen(format, line string, n int) string {
+	if isJSONFormat(format) {
+		return `{"sql_len":` + strconv.Itoa(n) + "," + strings.TrimPrefix(line, "{")
+	}
+	return strings.TrimSuffix(line, "\n") + " sql_len=" + strconv.Itoa(n) + "\n"
+}
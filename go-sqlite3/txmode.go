@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// querier is what the queries need from a transaction; both *sql.Tx and
+// the connection of a connTx provide it.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// txn is a transaction, whichever way it was begun.
+type txn interface {
+	querier
+	Commit() error
+	Rollback() error
+}
+
+// beginTx starts a transaction in the --tx-mode given.
+//
+// "deferred" is database/sql's own BeginTx: SQLite takes no lock until the
+// first statement, which the trace shows as the first +Tx+ event acquiring
+// the lock only when it reads or writes. "immediate" and "exclusive" cannot
+// be asked of BeginTx, so a connection is taken out of the pool and the
+// BEGIN is run on it by hand; the lock is then held from the traced BEGIN
+// on, and the AutoCommit flag flips from -AC- to +Tx+ right after it,
+// back again after the COMMIT or ROLLBACK.
+func beginTx(ctx context.Context, db *sql.DB, mode string) (txn, error) {
+	switch mode {
+	case "deferred":
+		return db.BeginTx(ctx, nil)
+	case "immediate", "exclusive":
+	default:
+		return nil, fmt.Errorf("unknown --tx-mode %q, want deferred, immediate or exclusive", mode)
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "BEGIN "+strings.ToUpper(mode)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &connTx{Conn: conn}, nil
+}
+
+// connTx is a transaction begun by hand on a dedicated connection, which
+// goes back to the pool when the transaction ends.
+type connTx struct {
+	*sql.Conn
+	done bool
+}
+
+func (t *connTx) Commit() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	defer t.Conn.Close()
+
+	// Not the caller's context: a cancelled one would leave the
+	// connection in the middle of a transaction.
+	if _, err := t.ExecContext(context.Background(), "COMMIT"); err != nil {
+		// A failed COMMIT, e.g. SQLITE_BUSY, keeps the transaction open.
+		t.ExecContext(context.Background(), "ROLLBACK")
+		return err
+	}
+	return nil
+}
+
+func (t *connTx) Rollback() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	defer t.Conn.Close()
+
+	_, err := t.ExecContext(context.Background(), "ROLLBACK")
+	return err
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// demoUDFSQL is the query --demo-udf runs to show go_upper at work.
+const demoUDFSQL = "SELECT go_upper(user_name) FROM user"
+
+// registerFuncs makes the sample's Go functions callable from SQL on conn.
+// Their calls run inside the statement, so the trace shows them only as
+// part of the statement text and its run time.
+func registerFuncs(conn *sqlite3.SQLiteConn) error {
+	// pure: the same text always gives the same result, which lets
+	// SQLite use the function in indexes and constant folding.
+	return conn.RegisterFunc("go_upper", strings.ToUpper, true)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+type stmtStart struct {
+	conn uintptr
+	at   time.Time
+}
+
+// wallClock measures, on the Go side, the time from the TraceStmt of a
+// statement to its TraceProfile. Set against the run time SQLite reports,
+// the difference is what cgo and the callbacks cost.
+//
+// Like rowCounter it has no lock, the TraceCollector serializes the calls.
+type wallClock struct {
+	started map[uintptr]stmtStart // StmtHandle -> its TraceStmt
+}
+
+func newWallClock() *wallClock {
+	return &wallClock{started: make(map[uintptr]stmtStart)}
+}
+
+// Record returns the wall time of the statement a TraceProfile event
+// finishes, and false for every other event.
+func (c *wallClock) Record(info sqlite3.TraceInfo) (time.Duration, bool) {
+	switch info.EventCode {
+	case sqlite3.TraceStmt:
+		c.started[info.StmtHandle] = stmtStart{conn: info.ConnHandle, at: time.Now()}
+	case sqlite3.TraceProfile:
+		s, ok := c.started[info.StmtHandle]
+		if !ok {
+			return 0, false
+		}
+		delete(c.started, info.StmtHandle)
+		return time.Since(s.at), true
+	case sqlite3.TraceClose:
+		// Statements whose profile never came.
+		for handle, s := range c.started {
+			if s.conn == info.ConnHandle {
+				delete(c.started, handle)
+			}
+		}
+	}
+	return 0, false
+}
+
+// writeWallTime reports the SQLite and the Go side timing of one statement,
+// in the same format as the rest of the trace.
+func writeWallTime(w io.Writer, format string, stmt uintptr, runNs int64, wall time.Duration) {
+	switch format {
+	case "text":
+		fmt.Fprintf(w, "Trace: stmt 0x%x run_ns %d wall_ns %d\n", stmt, runNs, wall.Nanoseconds())
+		return
+	case "logfmt":
+		io.WriteString(w, logfmtLine("stmt", fmt.Sprintf("0x%x", stmt), "run_ns", runNs, "wall_ns", wall.Nanoseconds()))
+		return
+	}
+	line, _ := json.Marshal(struct {
+		StmtHandle string `json:"stmt_handle"`
+		RunTimeNs  int64  `json:"run_time_ns"`
+		WallTimeNs int64  `json:"wall_time_ns"`
+	}{fmt.Sprintf("0x%x", stmt), runNs, wall.Nanoseconds()})
+	w.Write(append(line, '\n'))
+}
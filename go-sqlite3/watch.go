@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// watchPollInterval is how often --watch looks at the file.
+const watchPollInterval = 500 * time.Millisecond
+
+// watchQuery runs the statement in path, then again each time the file
+// changes, its modification time or size that is, until ctx is done.
+// The file is polled rather than watched with inotify and the like: it
+// works everywhere, editors that replace the file included.
+//
+// Every run starts with a marker line and has a transaction and a
+// --timeout of its own; a run that fails is logged and the watch goes on.
+func watchQuery(ctx context.Context, db *sql.DB, path string, opts *options) error {
+	var (
+		last time.Time
+		size int64 = -1
+		runs int
+	)
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	for {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !fi.ModTime().Equal(last) || fi.Size() != size {
+			last, size = fi.ModTime(), fi.Size()
+			runs++
+			fmt.Printf("--------- watch run %d: %s\n", runs, path)
+			if err := watchRun(ctx, db, path, opts); err != nil {
+				category, _ := classifyError(err)
+				log.Printf("watch run %d got %s error: %s\n", runs, category, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func watchRun(ctx context.Context, db *sql.DB, path string, opts *options) error {
+	query, err := readQuery(path)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	var (
+		tx     txn
+		runner querier = db
+	)
+	if !opts.noTx {
+		if tx, err = beginTx(ctx, db, opts.txMode); err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		runner = tx
+	}
+	if opts.requestID != "" {
+		if err := tagRequest(WithRequestID(ctx, opts.requestID), runner); err != nil {
+			return err
+		}
+	}
+	out, _ := newRowWriter(opts.format, os.Stdout) // validated by parseOptions
+	qctx, cancelStmt := withStmtTimeout(ctx, opts.stmtTimeout)
+	defer cancelStmt()
+	if err := runQuery(qctx, runner, out, query); err != nil {
+		return err
+	}
+	if tx != nil {
+		return tx.Commit()
+	}
+	return nil
+}
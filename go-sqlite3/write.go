@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// demoWriteSQL and demoUndoSQL are the writes of --demo-write: a copy of
+// a token row, whatever the columns of the table, deleted again so that
+// the runs do not pile rows up.
+const (
+	demoWriteSQL = "insert into token select * from token limit 1"
+	demoUndoSQL  = "delete from token where rowid = ?"
+)
+
+// execWrite runs a write statement and reports what sqlite3_changes and
+// sqlite3_last_insert_rowid said about it, which no trace event carries:
+// only the writes run through here get the line, not those run as a
+// --query or in a --script, and not the rows a trigger changed.
+func execWrite(ctx context.Context, tx querier, collector *TraceCollector, query string, args ...interface{}) (lastID int64, err error) {
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if lastID, err = res.LastInsertId(); err != nil {
+		return 0, err
+	}
+	collector.Executed(affected, lastID)
+	return lastID, nil
+}
+
+// demoWrite runs the --demo-write statements.
+func demoWrite(ctx context.Context, tx querier, collector *TraceCollector) error {
+	id, err := execWrite(ctx, tx, collector, demoWriteSQL)
+	if err != nil {
+		return err
+	}
+	_, err = execWrite(ctx, tx, collector, demoUndoSQL, id)
+	return err
+}
+
+// Executed writes the result of a write run by execWrite.
+func (c *TraceCollector) Executed(affected, lastID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.settings.level != logInfo || c.eventsOnly() {
+		return
+	}
+	w := c.settings.out
+	switch c.settings.format {
+	case "text":
+		fmt.Fprintf(w, "Trace: exec %d rows affected, last insert id %d\n", affected, lastID)
+		return
+	case "logfmt":
+		io.WriteString(w, logfmtLine("event", "exec", "rows_affected", affected, "last_insert_id", lastID))
+		return
+	}
+	line, _ := json.Marshal(struct {
+		Event        string `json:"event"`
+		RowsAffected int64  `json:"rows_affected"`
+		LastInsertID int64  `json:"last_insert_id"`
+	}{"exec", affected, lastID})
+	w.Write(append(line, '\n'))
+}